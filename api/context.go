@@ -1,9 +1,17 @@
 package api
 
 import (
+	"db2rest/auth"
 	"db2rest/vexpr"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Context struct {
@@ -11,6 +19,65 @@ type Context struct {
 	request 	*http.Request
 	response	http.ResponseWriter
 	values		map[string]interface{}
+	sources		map[string]map[string]interface{}
+	args		[]interface{}
+}
+
+// StatusError is an error responded with its own http status code, and
+// optionally its own error code and Retry-After seconds.
+type StatusError struct {
+	Status		int
+	Code		string
+	RetryAfter	int
+	Err			error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+// FieldError is a param failing validation. Code is the validator, or type
+// when the value is not of the type of the param.
+type FieldError struct {
+	Field	string	`json:"field"`
+	Code	string	`json:"code"`
+	Message	string	`json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// ValidationError is all the params of a request failing validation,
+// responded with 400.
+type ValidationError struct {
+	Errors	[]*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// errorCode returns the code of an error response with the status code,
+// e.g. not_found for 404.
+func errorCode(statusCode int) string {
+	text := http.StatusText(statusCode)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.Replace(strings.Replace(text, " ", "_", -1), "-", "_", -1))
+}
+
+// statusOf returns the status code of a StatusError, or def for other errors.
+func statusOf(err error, def int) int {
+	if se, ok := err.(*StatusError); ok {
+		return se.Status
+	}
+	return def
 }
 
 func (ctx *Context) Respond(statusCode int, contentType string, body []byte) {
@@ -27,13 +94,42 @@ func (ctx *Context) RespondJsonString(statusCode int, body string) {
 	ctx.Respond(statusCode, "application/json", []byte(body))
 }
 
+// errorBody is the envelope of error responses.
+type errorBody struct {
+	Found	*bool			`json:"found,omitempty"`
+	Error	string			`json:"error"`
+	Code	string			`json:"code"`
+	Details	[]*FieldError	`json:"details,omitempty"`
+}
+
+func (ctx *Context) respondBody(statusCode int, body *errorBody) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("fail to encode error: %v", err)
+	}
+	ctx.Respond(statusCode, "application/json", b)
+}
+
 func (ctx *Context) RespondError(statusCode int, err error) {
-	s := `{"error":"` + err.Error() + `"}`
-	ctx.Respond(statusCode, "application/json", []byte(s))
+	body := &errorBody{Error: err.Error(), Code: errorCode(statusCode)}
+	switch t := err.(type) {
+	case *ValidationError:
+		body.Code = "invalid_params"
+		body.Details = t.Errors
+	case *StatusError:
+		if t.Code != "" {
+			body.Code = t.Code
+		}
+		if t.RetryAfter > 0 {
+			ctx.response.Header().Set("Retry-After", strconv.Itoa(t.RetryAfter))
+		}
+	}
+	ctx.respondBody(statusCode, body)
 }
 
 func (ctx *Context) RespondNotFound() {
-	ctx.Respond(404, "application/json", []byte(`{"found": false}`))
+	found := false
+	ctx.respondBody(404, &errorBody{Found: &found, Error: "not found", Code: errorCode(404)})
 }
 
 func (ctx *Context) Header(name string) string {
@@ -41,7 +137,16 @@ func (ctx *Context) Header(name string) string {
 }
 
 func (ctx *Context) Param(name string) string {
-	v, err := vexpr.GetString(ctx.values, name, "")
+	v := ctx.Value(name)
+	if p, ok := ctx.api.paramMap[name]; ok {
+		return p.Format(v)
+	}
+	return formatValue(v)
+}
+
+// Value returns the value of the named param, typed by its declaration.
+func (ctx *Context) Value(name string) interface{} {
+	v, err := vexpr.Get(ctx.values, name)
 	if err != nil {
 		log.Printf("fail to evaluate value of %s: %v", name, err)
 	}
@@ -67,5 +172,112 @@ func (c *Context) Quote(str string) string {
 	if str == "" {
 		return "null"
 	}
-	return "'" + str + "'"
+	return c.api.db.Quote(str)
+}
+
+// Bind appends the value of the named param to the statement arguments and
+// returns the driver placeholder referring to it.
+func (ctx *Context) Bind(name string) string {
+	ctx.args = append(ctx.args, bindValue(ctx.Value(name)))
+	return ctx.api.db.Placeholder(len(ctx.args))
+}
+
+// Claim returns the named claim of the token of the caller, or "" without
+// a token. Dotted names such as realm_access.roles refer to nested claims.
+func (ctx *Context) Claim(name string) string {
+	return formatValue(ctx.claim(name))
+}
+
+// BindClaim binds the named claim of the token of the caller, like Bind.
+func (ctx *Context) BindClaim(name string) string {
+	ctx.args = append(ctx.args, bindValue(ctx.claim(name)))
+	return ctx.api.db.Placeholder(len(ctx.args))
+}
+
+// Claims returns all the claims of the token of the caller as json, or ""
+// without a token.
+func (ctx *Context) Claims() string {
+	id := auth.FromContext(ctx.request.Context())
+	if id == nil || id.Claims == nil {
+		return ""
+	}
+	b, err := json.Marshal(id.Claims)
+	if err != nil {
+		log.Printf("fail to encode claims: %v", err)
+	}
+	return string(b)
+}
+
+func (ctx *Context) claim(name string) interface{} {
+	id := auth.FromContext(ctx.request.Context())
+	if id == nil || id.Claims == nil {
+		return nil
+	}
+	return auth.Claim(id.Claims, name)
+}
+
+// BindList binds each item of the named array param and returns the list of
+// their placeholders, for in (...). An empty list is NULL, matching nothing.
+func (ctx *Context) BindList(name string) string {
+	list, ok := ctx.Value(name).([]interface{})
+	if !ok {
+		return ctx.Bind(name)
+	}
+	if len(list) == 0 {
+		return "NULL"
+	}
+	placeholders := make([]string, len(list))
+	for i, item := range list {
+		ctx.args = append(ctx.args, bindValue(item))
+		placeholders[i] = ctx.api.db.Placeholder(len(ctx.args))
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// BindArray binds the named array param as a single postgres array, for
+// = any(...).
+func (ctx *Context) BindArray(name string) (string, error) {
+	if ctx.api.db.Driver() != "postgres" {
+		return "", fmt.Errorf("BindArray is not supported by %s", ctx.api.db.Driver())
+	}
+	v := ctx.Value(name)
+	list, ok := v.([]interface{})
+	if !ok && v != nil {
+		list = []interface{}{v}
+	}
+	items := make([]interface{}, len(list))
+	for i, item := range list {
+		items[i] = bindValue(item)
+	}
+	ctx.args = append(ctx.args, pq.Array(items))
+	return ctx.api.db.Placeholder(len(ctx.args)), nil
+}
+
+func bindValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, string, bool, int64, time.Time:
+		return t
+	case json.RawMessage:
+		return string(t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < 1<<53 {
+			return int64(t)
+		}
+		return t
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			log.Printf("fail to encode bind value %v: %v", t, err)
+			return nil
+		}
+		return string(b)
+	}
 }
\ No newline at end of file
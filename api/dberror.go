@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"db2rest/db"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// db_errors are the status codes and messages responded for the classes of
+// database errors.
+var db_errors = map[string]struct {
+	status	int
+	message	string
+}{
+	db.UniqueViolation:			{409, "the value already exists"},
+	db.ForeignKeyViolation:		{422, "the referenced value does not exist"},
+	db.ForeignKeyRestrict:		{409, "the value is still referenced"},
+	db.NotNullViolation:		{422, "a required value is missing"},
+	db.CheckViolation:			{422, "a value is not allowed"},
+	db.SerializationFailure:	{503, "the transaction conflicted with another, please retry"},
+	db.QueryCanceled:			{504, "the query timed out"},
+}
+
+// db_retry_after is the Retry-After seconds responded for serialization
+// failures.
+const db_retry_after = 1
+
+// dbError maps an error of the database driver to a StatusError by its
+// class, or to a timeout when the deadline of the request is exceeded. The
+// message of a violated constraint can be set by the endpoint's
+// constraint_errors. Driver messages, which name tables and constraints, are
+// only responded in debug. Other errors are returned as they are.
+func (ctx *Context) dbError(err error) error {
+	if err == context.DeadlineExceeded || ctx.request.Context().Err() == context.DeadlineExceeded {
+		log.Printf("db error: %v", err)
+		return &StatusError{Status: 504, Code: "timeout", Err: fmt.Errorf("the request timed out after %v", ctx.api.timeout)}
+	}
+	e := ctx.api.db.Classify(err)
+	if e == nil {
+		return err
+	}
+	log.Printf("db error: %v", err)
+
+	se := &StatusError{Status: 500, Code: "database_error", Err: errors.New("database error")}
+	if m, ok := db_errors[e.Class]; ok {
+		se.Status = m.status
+		se.Code = e.Class
+		se.Err = errors.New(m.message)
+	}
+	if e.Class == db.SerializationFailure {
+		se.RetryAfter = db_retry_after
+	}
+	if msg, ok := ctx.api.constraintErrors[e.Constraint]; ok && e.Constraint != "" {
+		se.Err = errors.New(msg)
+	} else if ctx.api.debug {
+		se.Err = err
+	}
+	return se
+}
@@ -1,9 +1,15 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"db2rest/auth"
 	"db2rest/db"
+	"database/sql"
 	"db2rest/conf"
 	"net/url"
+	"mime"
+	"unicode/utf8"
 	"strings"
 	"errors"
 	"encoding/json"
@@ -12,6 +18,8 @@ import (
 	"log"
 	"fmt"
 	"net/http"
+	"time"
+	"github.com/gorilla/mux"
 )
 
 type Endpoint struct {
@@ -20,55 +28,131 @@ type Endpoint struct {
 	url				string
 	method			string
 	params			[]*Param
+	paramMap		map[string]*Param
 	paramDefaults	map[string]string
+	precedence		[]string
 	fieldMap		map[string]string
+	kindMap			map[string]string
 	csvMap			map[string]string
 	tpl				*template.Template
+	statements		[]*template.Template
+	txOptions		*sql.TxOptions
 	sqltype			string
 	output 			string
+	nulls			string
+	maxRows			int
+	csvDelimiter	rune
+	csvBom			bool
+	csvHeader		bool
+	csvNull			string
+	csvCrlf			bool
+	paginate		string
+	pageSize		int
+	maxPageSize		int
+	pageTotal		bool
+	keyset			[]string
+	keysetDesc		bool
+	groupBy			[]string
+	nest			string
+	nestColumns		[]string
+	xmlRoot			string
+	xmlRow			string
+	flushRows		int
 	converter 		string
 	converter_csv	string
+	constraintErrors	map[string]string
+	debug			bool
+	timeout			time.Duration
+	rule			*auth.Rule
+	session			[]*sessionSetting
+	sessionRole		*template.Template
 	fun1			func(*Context) (db.Output, error)
-	fun2			func(db.Output)
+	fun2			func(context.Context, *db.Session, db.Output)
 }
 
-func NewEndpoint(conf *conf.Conf, db *db.Client) (*Endpoint, error) {
+func NewEndpoint(conf *conf.Conf, global *conf.Conf, db *db.Client) (*Endpoint, error) {
 	e := &Endpoint{conf: conf, db: db}
+	e.debug = conf.GetBool("debug", global.GetBool("debug", false))
+	e.timeout = time.Duration(conf.GetInt("timeout_seconds", global.GetInt("timeout_seconds", 0))) * time.Second
 	e.url = conf.GetString("url", "")
 	e.method = conf.GetString("method", "GET")
-	e.output = conf.GetString("output_type", "list")
+	e.paginate = conf.GetString("paginate", "")
+	if e.paginate != "" {
+		e.output = conf.GetString("output_type", "page")
+	} else {
+		e.output = conf.GetString("output_type", "list")
+	}
+	e.nulls = conf.GetString("output_nulls", "omit")
+	e.maxRows = conf.GetInt("max_rows", 0)
+	e.flushRows = conf.GetInt("flush_rows", 100)
+	e.csvBom = conf.GetBool("csv_bom", false)
+	e.csvHeader = conf.GetBool("csv_header", true)
+	e.csvNull = conf.GetString("csv_null", "")
+	e.csvCrlf = conf.GetBool("csv_crlf", false)
+	e.xmlRoot = xmlName(conf.GetString("xml_root", "rows"))
+	e.xmlRow = xmlName(conf.GetString("xml_row", "row"))
 	e.converter = conf.GetString("output_converter", "lowercamel")
 	e.converter_csv = conf.GetString("output_converter_csv", "screamingsnake")
 	e.sqltype = conf.GetString("sql_type", "query")
 	if e.url == ""{
 		return nil, errors.New("api url is not set")
 	}
+	if err := e.InitCsvDelimiter(); err != nil {
+		return nil, err
+	}
+	if err := e.InitNest(); err != nil {
+		return nil, err
+	}
+	if err := e.InitPaginate(); err != nil {
+		return nil, err
+	}
+	if e.flushRows <= 0 {
+		return nil, fmt.Errorf("invalid flush_rows %d", e.flushRows)
+	}
+	if e.nulls != "omit" && e.nulls != "null" {
+		return nil, fmt.Errorf("invalid output_nulls %s", e.nulls)
+	}
 	if err := e.InitParams(); err != nil 		{return nil, err}
 	if err := e.InitParamDefaults(); err != nil {return nil, err}
+	if err := e.InitPrecedence(); err != nil 	{return nil, err}
 	if err := e.InitFieldMap(); err != nil 		{return nil, err}
 	if err := e.InitCsvMap(); err != nil 		{return nil, err}
+	if err := e.InitConstraintErrors(); err != nil {return nil, err}
+	if err := e.InitRule(); err != nil 			{return nil, err}
 	if err := e.InitTemplate(); err != nil 		{return nil, err}
+	if err := e.InitSession(); err != nil 		{return nil, err}
 	if err := e.InitFunc(); err != nil 			{return nil, err}
 	return e, nil
 }
 
 func (e *Endpoint) Handle(resp http.ResponseWriter, req *http.Request) {
 	log.Printf("%s %s\n", req.Method, req.RequestURI)
+	if e.timeout > 0 {
+		c, cancel := context.WithTimeout(req.Context(), e.timeout)
+		defer cancel()
+		req = req.WithContext(c)
+	}
 	ctx, err := e.Context(resp, req)
 	if err != nil {
-		ctx.RespondError(400, err)
+		ctx.RespondError(statusOf(err, 400), err)
 		return
 	}
 	output, err := e.fun1(ctx)
 	if err != nil {
-		ctx.RespondError(500, err)
+		ctx.RespondError(statusOf(err, 500), err)
+		return
+	}
+	session, err := e.Session(ctx)
+	if err != nil {
+		ctx.RespondError(statusOf(err, 500), err)
 		return
 	}
-	e.fun2(output)
+	e.fun2(req.Context(), session, output)
 }
 
 func (e *Endpoint) InitParams() error {
 	e.params = make([]*Param, 0)
+	e.paramMap = make(map[string]*Param)
 	it, err := e.conf.Iterator("params")
 	if err != nil {
 		return err
@@ -81,10 +165,11 @@ func (e *Endpoint) InitParams() error {
 		s := i.GetString("_", "")
 		fields := strings.Fields(s)
 		p := &Param{name: fields[0]}
-		if err := p.ParseValidators(fields[1:]...); err != nil {
+		if err := p.Parse(fields[1:]...); err != nil {
 			return err
 		}
 		e.params = append(e.params, p)
+		e.paramMap[p.name] = p
 	}
 	return nil
 }
@@ -104,8 +189,32 @@ func (e *Endpoint) InitParamDefaults() error {
 	return nil
 }
 
+var sources = map[string]bool{"path": true, "query": true, "body": true, "default": true}
+
+// max_memory is the part of a multipart form kept in memory, see
+// http.Request.ParseMultipartForm.
+const max_memory = 32 << 20
+
+// InitPrecedence reads the sources of request values, highest precedence
+// first. Sources left out are ignored.
+func (e *Endpoint) InitPrecedence() error {
+	s := e.conf.GetString("param_precedence", "path,query,body,default")
+	e.precedence = make([]string, 0)
+	for _, source := range strings.Split(s, ",") {
+		source = strings.TrimSpace(source)
+		if !sources[source] {
+			return fmt.Errorf("invalid param_precedence source %s", source)
+		}
+		e.precedence = append(e.precedence, source)
+	}
+	return nil
+}
+
+// InitFieldMap reads output_map entries "column: name [kind]", where the
+// optional kind overrides how the column is rendered in json.
 func (e *Endpoint) InitFieldMap() error {
 	e.fieldMap = make(map[string]string)
+	e.kindMap = make(map[string]string)
 	it, err := e.conf.Iterator("output_map")
 	if err != nil {
 		return err
@@ -118,7 +227,20 @@ func (e *Endpoint) InitFieldMap() error {
 		s := i.GetString("_", "")
 		if s != "" {
 			parts := strings.SplitN(s, ":", 2)
-			e.fieldMap[parts[0]] = strings.TrimSpace(parts[1])
+			if len(parts) < 2 {
+				return fmt.Errorf("invalid output_map: %s", s)
+			}
+			fields := strings.Fields(parts[1])
+			if len(fields) == 0 || len(fields) > 2 {
+				return fmt.Errorf("invalid output_map: %s", s)
+			}
+			e.fieldMap[parts[0]] = fields[0]
+			if len(fields) > 1 {
+				if !kinds[fields[1]] {
+					return fmt.Errorf("invalid output_map kind: %s", s)
+				}
+				e.kindMap[parts[0]] = fields[1]
+			}
 		}
 	}
 	return nil
@@ -144,7 +266,143 @@ func (e *Endpoint) InitCsvMap() error {
 	return nil
 }
 
+// InitRule reads the scopes, roles and claims as "name: value" the caller
+// needs to call the api, unless the api is public.
+func (e *Endpoint) InitRule() error {
+	e.rule = &auth.Rule{Public: e.conf.GetBool("public", false), Claims: make(map[string]string)}
+	var err error
+	if e.rule.Scopes, err = e.strings("scopes"); err != nil {
+		return err
+	}
+	if e.rule.Roles, err = e.strings("roles"); err != nil {
+		return err
+	}
+	claims, err := e.strings("claims")
+	if err != nil {
+		return err
+	}
+	for _, s := range claims {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) < 2 {
+			return fmt.Errorf("invalid claims: %s", s)
+		}
+		e.rule.Claims[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return nil
+}
+
+// InitConstraintErrors reads the messages responded for violations of the
+// named constraints, as "constraint: message".
+func (e *Endpoint) InitConstraintErrors() error {
+	e.constraintErrors = make(map[string]string)
+	list, err := e.strings("constraint_errors")
+	if err != nil {
+		return err
+	}
+	for _, s := range list {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("invalid constraint_errors: %s", s)
+		}
+		e.constraintErrors[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return nil
+}
+
+func (e *Endpoint) InitCsvDelimiter() error {
+	s := e.conf.GetString("csv_delimiter", ",")
+	if s == "tab" {
+		s = "\t"
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' || r[0] == utf8.RuneError {
+		return fmt.Errorf("invalid csv_delimiter %q", s)
+	}
+	e.csvDelimiter = r[0]
+	return nil
+}
+
+func (e *Endpoint) InitPaginate() (err error) {
+	e.pageSize = e.conf.GetInt("page_size", 20)
+	e.maxPageSize = e.conf.GetInt("max_page_size", 100)
+	e.pageTotal = e.conf.GetBool("page_total", false)
+	if e.keyset, err = e.strings("keyset"); err != nil {
+		return
+	}
+	for i, s := range e.keyset {
+		fields := strings.Fields(s)
+		desc := len(fields) > 1 && strings.ToLower(fields[1]) == "desc"
+		if len(fields) == 0 || len(fields) > 2 || (len(fields) == 2 && !desc && strings.ToLower(fields[1]) != "asc") {
+			return fmt.Errorf("invalid keyset column %s", s)
+		}
+		if i > 0 && desc != e.keysetDesc {
+			return errors.New("keyset columns must be ordered in the same direction")
+		}
+		e.keyset[i] = fields[0]
+		e.keysetDesc = desc
+	}
+
+	switch e.paginate {
+	case "":
+		if e.output == "page" {
+			return errors.New("page output requires paginate")
+		}
+	case "offset":
+	case "keyset":
+		if len(e.keyset) == 0 {
+			return errors.New("keyset pagination requires keyset columns")
+		}
+	default:
+		return fmt.Errorf("invalid paginate %s", e.paginate)
+	}
+	if e.paginate != "" && e.output != "page" {
+		return fmt.Errorf("paginate requires page output, not %s", e.output)
+	}
+	if e.pageSize <= 0 || e.maxPageSize < e.pageSize {
+		return fmt.Errorf("invalid page_size %d or max_page_size %d", e.pageSize, e.maxPageSize)
+	}
+	return
+}
+
+func (e *Endpoint) InitNest() (err error) {
+	e.nest = e.conf.GetString("nest", "")
+	if e.groupBy, err = e.strings("group_by"); err != nil {
+		return
+	}
+	if e.nestColumns, err = e.strings("nest_columns"); err != nil {
+		return
+	}
+	if e.output == "nested" && (e.nest == "" || len(e.groupBy) == 0 || len(e.nestColumns) == 0) {
+		return errors.New("nested output requires group_by, nest and nest_columns")
+	}
+	return
+}
+
+// strings reads an array of strings from the endpoint config.
+func (e *Endpoint) strings(name string) ([]string, error) {
+	return confStrings(e.conf, name)
+}
+
+func confStrings(conf *conf.Conf, name string) ([]string, error) {
+	it, err := conf.Iterator(name)
+	if err != nil {
+		return nil, err
+	}
+	s := make([]string, 0)
+	for it.HasNext() {
+		i, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, i.GetString("_", ""))
+	}
+	return s, nil
+}
+
 func (e *Endpoint) InitTemplate() error {
+	if e.sqltype == "transaction" {
+		return e.InitStatements()
+	}
 	name := fmt.Sprintf("%s%s", e.method, e.url)
 	sql := e.conf.GetString("sql", "")
 	if sql == "" {
@@ -158,6 +416,84 @@ func (e *Endpoint) InitTemplate() error {
 	return nil
 }
 
+func (e *Endpoint) InitStatements() error {
+	e.statements = make([]*template.Template, 0)
+	it, err := e.conf.Iterator("statements")
+	if err != nil {
+		return err
+	}
+	for it.HasNext() {
+		i, err := it.Next()
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s%s[%d]", e.method, e.url, len(e.statements))
+		tpl, err := template.New(name).Parse(i.GetString("_", ""))
+		if err != nil {
+			return err
+		}
+		e.statements = append(e.statements, tpl)
+	}
+	if len(e.statements) == 0 {
+		return errors.New("statements are not configured")
+	}
+
+	isolation, err := db.ParseIsolation(e.conf.GetString("isolation", "default"))
+	if err != nil {
+		return err
+	}
+	e.txOptions = &sql.TxOptions{Isolation: isolation, ReadOnly: e.conf.GetBool("read_only", false)}
+	return nil
+}
+
+// sessionSetting is a setting of the database session, with its value
+// rendered for each request.
+type sessionSetting struct {
+	name	string
+	tpl		*template.Template
+}
+
+// InitSession reads the session settings as "name: template" and the
+// session_role template of the api, or of its datasource when the api has
+// none.
+func (e *Endpoint) InitSession() error {
+	settings, err := e.strings("session")
+	if err != nil {
+		return err
+	}
+	if len(settings) == 0 {
+		if settings, err = confStrings(e.db.Conf(), "session"); err != nil {
+			return err
+		}
+	}
+	role := e.conf.GetString("session_role", e.db.Conf().GetString("session_role", ""))
+	if len(settings) == 0 && role == "" {
+		return nil
+	}
+	if e.db.Driver() != "postgres" {
+		return fmt.Errorf("session settings are not supported by %s", e.db.Driver())
+	}
+
+	for _, s := range settings {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) < 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("invalid session: %s", s)
+		}
+		name := strings.TrimSpace(parts[0])
+		tpl, err := template.New(e.method + e.url + " " + name).Parse(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return err
+		}
+		e.session = append(e.session, &sessionSetting{name: name, tpl: tpl})
+	}
+	if role != "" {
+		if e.sessionRole, err = template.New(e.method + e.url + " role").Parse(role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (e *Endpoint) InitFunc() error {
 	switch e.sqltype {
 	case "query":
@@ -166,6 +502,9 @@ func (e *Endpoint) InitFunc() error {
 	case "update":
 		e.fun1 = e.UpdateOutput
 		e.fun2 = e.db.Exec
+	case "transaction":
+		e.fun1 = e.UpdateOutput
+		e.fun2 = e.db.Transaction
 	default:
 		return fmt.Errorf("invalid sql type %s", e.sqltype)
 	}
@@ -183,45 +522,188 @@ func (e *Endpoint) Context(resp http.ResponseWriter, req *http.Request) (*Contex
 	return ctx, nil
 }
 
+// Validate converts each param to its type and checks it, and fails with
+// all the params which are invalid.
 func (e *Endpoint) Validate(ctx *Context) error {
+	var errs []*FieldError
 	for _, p := range e.params {
-		if err := p.Validate(ctx); err != nil {
-			return err
+		if err := p.Coerce(ctx); err != nil {
+			errs = append(errs, err)
+		} else if err := p.Validate(ctx); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
 	return nil
 }
 
+// Parse collects the values of the request from each source, and merges
+// them by param_precedence.
 func (e *Endpoint) Parse(ctx *Context) error {
-	ctx.values = make(map[string]interface{})
+	ctx.sources = make(map[string]map[string]interface{})
+
+	defaults := make(map[string]interface{})
 	for p := range e.paramDefaults {
-		ctx.values[p] = e.paramDefaults[p]
+		defaults[p] = e.paramDefaults[p]
 	}
-	body, err := ioutil.ReadAll(ctx.request.Body)
-	if err != nil {
+	ctx.sources["default"] = defaults
+
+	if err := e.ParseBody(ctx); err != nil {
 		return err
 	}
-	if len(body) > 0 {
-		if err := json.Unmarshal(body, &ctx.values); err != nil {
-			return err
-		}
-	}
+
 	query, err := url.ParseQuery(ctx.request.URL.RawQuery)
 	if err != nil {
 		return err
 	}
-	for i := range query {
-		ctx.values[i] = query[i][0]
+	ctx.sources["query"] = e.formValues(query)
+
+	values := make(map[string]interface{})
+	for k, v := range mux.Vars(ctx.request) {
+		values[k] = v
+	}
+	ctx.sources["path"] = values
+
+	ctx.values = make(map[string]interface{})
+	for i := len(e.precedence) - 1; i >= 0; i-- {
+		for k, v := range ctx.sources[e.precedence[i]] {
+			ctx.values[k] = v
+		}
+	}
+	for _, p := range e.params {
+		if p.source == "" {
+			continue
+		}
+		if v, ok := p.Lookup(ctx); ok {
+			ctx.values[p.name] = v
+		} else if v, ok := ctx.sources["default"][p.name]; ok {
+			ctx.values[p.name] = v
+		} else {
+			delete(ctx.values, p.name)
+		}
 	}
 	return nil
 }
 
-func (e *Endpoint) SQL(ctx *Context) (sql string, err error) {
+// ParseBody reads the body by its Content-Type: json, which is also assumed
+// without Content-Type, or url encoded and multipart forms. Form values are
+// both in the body and form sources.
+func (e *Endpoint) ParseBody(ctx *Context) error {
+	req := ctx.request
+	values := make(map[string]interface{})
+	ctx.sources["body"] = values
+	ctx.sources["form"] = make(map[string]interface{})
+
+	mediaType := ""
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return &StatusError{Status: 415, Err: fmt.Errorf("invalid content type %s", ct)}
+		}
+	}
+
+	var form url.Values
+	switch {
+	case mediaType == "" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		if len(body) > 0 {
+			d := json.NewDecoder(bytes.NewReader(body))
+			d.UseNumber()
+			if err := d.Decode(&values); err != nil {
+				return err
+			}
+		}
+		return nil
+	case mediaType == "application/x-www-form-urlencoded":
+		if err := req.ParseForm(); err != nil {
+			return err
+		}
+		form = req.PostForm
+	case mediaType == "multipart/form-data":
+		if err := req.ParseMultipartForm(max_memory); err != nil {
+			return err
+		}
+		form = req.MultipartForm.Value
+	default:
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		if len(body) > 0 {
+			return &StatusError{Status: 415, Err: fmt.Errorf("unsupported content type %s", mediaType)}
+		}
+		return nil
+	}
+	for k, v := range e.formValues(form) {
+		values[k] = v
+		ctx.sources["form"][k] = v
+	}
+	return nil
+}
+
+// formValues takes the first value of each name, or all the values of array
+// params.
+func (e *Endpoint) formValues(form url.Values) map[string]interface{} {
+	values := make(map[string]interface{})
+	for k, vs := range form {
+		if p, ok := e.paramMap[k]; ok && p.array {
+			list := make([]interface{}, len(vs))
+			for i, v := range vs {
+				list[i] = v
+			}
+			values[k] = list
+		} else {
+			values[k] = vs[0]
+		}
+	}
+	return values
+}
+
+func (e *Endpoint) SQL(ctx *Context) (sql string, args []interface{}, err error) {
+	return e.render(e.tpl, ctx)
+}
+
+func (e *Endpoint) Statement(ctx *Context, i int) (sql string, args []interface{}, err error) {
+	return e.render(e.statements[i], ctx)
+}
+
+// Session renders the session settings and role of the request.
+func (e *Endpoint) Session(ctx *Context) (*db.Session, error) {
+	if len(e.session) == 0 && e.sessionRole == nil {
+		return nil, nil
+	}
+	session := &db.Session{}
 	var buf strings.Builder
-	if err = e.tpl.Execute(&buf, ctx); err != nil {
+	for _, s := range e.session {
+		buf.Reset()
+		if err := s.tpl.Execute(&buf, ctx); err != nil {
+			return nil, err
+		}
+		session.Settings = append(session.Settings, db.Setting{Name: s.name, Value: buf.String()})
+	}
+	if e.sessionRole != nil {
+		buf.Reset()
+		if err := e.sessionRole.Execute(&buf, ctx); err != nil {
+			return nil, err
+		}
+		session.Role = strings.TrimSpace(buf.String())
+	}
+	return session, nil
+}
+
+func (e *Endpoint) render(tpl *template.Template, ctx *Context) (sql string, args []interface{}, err error) {
+	var buf strings.Builder
+	ctx.args = nil
+	if err = tpl.Execute(&buf, ctx); err != nil {
 		return
 	}
 	sql = e.db.FormatSQL(buf.String())
+	args = ctx.args
 	return
 }
 
@@ -229,6 +711,24 @@ func (e *Endpoint) QueryOutput(ctx *Context) (db.Output, error) {
 	out := e.output
 	if ctx.Bool("csv") {
 		out = "csv"
+	} else if ctx.Bool("ndjson") {
+		out = "ndjson"
+	} else if ctx.Bool("xlsx") {
+		out = "xlsx"
+	} else if ctx.Bool("xml") {
+		out = "xml"
+	} else if ctx.Bool("tsv") {
+		out = "tsv"
+	} else {
+		ctx.response.Header().Add("Vary", "Accept")
+		var err error
+		if out, err = Negotiate(ctx.Header("Accept"), e.output); err != nil {
+			return nil, &StatusError{Status: 406, Err: err}
+		}
+	}
+	// pages are only limited in the page output
+	if e.paginate != "" && out != e.output {
+		return nil, &StatusError{Status: 406, Err: fmt.Errorf("paginated api is only available as %s", mediaTypeOf(e.output))}
 	}
 	switch out {
 	case "list":
@@ -237,6 +737,22 @@ func (e *Endpoint) QueryOutput(ctx *Context) (db.Output, error) {
 		return NewSingleOutput(ctx), nil
 	case "csv":
 		return NewCsvOutput(ctx), nil
+	case "ndjson":
+		return NewNdjsonOutput(ctx), nil
+	case "xlsx":
+		return NewXlsxOutput(ctx), nil
+	case "xml":
+		return NewXmlOutput(ctx), nil
+	case "tsv":
+		return NewTsvOutput(ctx), nil
+	case "nested":
+		return NewNestedOutput(ctx), nil
+	case "page":
+		o, err := NewPageOutput(ctx)
+		if err != nil {
+			return nil, &StatusError{Status: 400, Err: err}
+		}
+		return o, nil
 	default:
 		return nil, fmt.Errorf("invalid output type %s", out)
 	}
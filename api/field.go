@@ -1,8 +1,15 @@
 package api
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 	"github.com/iancoleman/strcase"
 )
 
@@ -10,6 +17,80 @@ type Field struct {
 	column 		string
 	dbType		string
 	name		string
+	kind		string
+	elem		string
+	layout		string
+	scratch		bytes.Buffer
+}
+
+// kinds of json value a column is rendered as, which can be overridden per
+// column in output_map.
+var kinds = map[string]bool{
+	"string":	true,
+	"number":	true,
+	"bool":		true,
+	"json":		true,
+	"array":	true,
+	"base64":	true,
+	"time":		true,
+	"date":		true,
+	"timeofday":	true,
+}
+
+var dbKinds = map[string]string{
+	"INT":				"number",
+	"INT2":				"number",
+	"INT4":				"number",
+	"INT8":				"number",
+	"INTEGER":			"number",
+	"TINYINT":			"number",
+	"SMALLINT":			"number",
+	"MEDIUMINT":		"number",
+	"BIGINT":			"number",
+	"OID":				"number",
+	"DECIMAL":			"number",
+	"NUMERIC":			"number",
+	"FLOAT":			"number",
+	"FLOAT4":			"number",
+	"FLOAT8":			"number",
+	"REAL":				"number",
+	"DOUBLE":			"number",
+	"DOUBLE PRECISION":	"number",
+	"BOOL":				"bool",
+	"BOOLEAN":			"bool",
+	"JSON":				"json",
+	"JSONB":			"json",
+	"BYTEA":			"base64",
+	"BLOB":				"base64",
+	"TINYBLOB":			"base64",
+	"MEDIUMBLOB":		"base64",
+	"LONGBLOB":			"base64",
+	"BINARY":			"base64",
+	"VARBINARY":		"base64",
+	"TIMESTAMP":		"time",
+	"TIMESTAMPTZ":		"time",
+	"DATETIME":			"time",
+	"DATE":				"date",
+	"TIME":				"timeofday",
+	"TIMETZ":			"timeofday",
+}
+
+var layouts = map[string]string{
+	"time":			time.RFC3339Nano,
+	"date":			"2006-01-02",
+	"timeofday":	"15:04:05.999999999",
+}
+
+// parseLayouts are the formats time values are scanned in, by database/sql
+// for time.Time values and by drivers returning text.
+var parseLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999Z07:00",
+	"15:04:05.999999999",
 }
 
 func NewField(ctx *Context, col *sql.ColumnType) *Field {
@@ -19,6 +100,14 @@ func NewField(ctx *Context, col *sql.ColumnType) *Field {
 	} else {
 		field.name = convert_name(field.column, ctx.api.converter)
 	}
+	field.kind, field.elem = columnKind(field.dbType)
+	if s, ok := ctx.api.kindMap[field.column]; ok {
+		field.kind = s
+	}
+	field.layout = layouts[field.kind]
+	if field.kind == "array" {
+		field.layout = layouts[field.elem]
+	}
 	return field
 }
 
@@ -32,6 +121,20 @@ func NewCsvField(ctx *Context, col *sql.ColumnType) *Field {
 	return field
 }
 
+// columnKind maps a database type name to the kind of json value, and for
+// postgres arrays (type names prefixed with _) the kind of the elements.
+func columnKind(dbType string) (string, string) {
+	t := strings.TrimPrefix(strings.ToUpper(dbType), "UNSIGNED ")
+	if strings.HasPrefix(t, "_") {
+		elem, _ := columnKind(t[1:])
+		return "array", elem
+	}
+	if kind, ok := dbKinds[t]; ok {
+		return kind, ""
+	}
+	return "string", ""
+}
+
 func convert_name(name, converter string) string {
 	switch converter {
 	case "camel":
@@ -55,19 +158,269 @@ func convert_name(name, converter string) string {
 	}
 }
 
-func (f *Field) AppendJsonName(b *strings.Builder) {
-	b.Write(json_quote)
-	b.WriteString(f.name)
-	b.Write(json_quote)
+func (f *Field) AppendJsonName(b writer) {
+	appendJsonString(b, []byte(f.name))
 }
 
-func (f *Field) AppendJsonValue(b *strings.Builder, value []byte) {
-	switch f.dbType {
-	case "DECIMAL", "INT", "BIGINT", "FLOAT8", "BOOL":
-		b.Write(value)
-	default:
+func (f *Field) AppendJsonValue(b writer, value []byte) {
+	switch f.kind {
+	case "array":
+		if !f.appendArray(b, value) {
+			appendJsonString(b, value)
+		}
+	case "json":
+		f.scratch.Reset()
+		if err := json.Compact(&f.scratch, value); err != nil {
+			appendJsonString(b, value)
+		} else {
+			b.Write(f.scratch.Bytes())
+		}
+	case "base64":
 		b.Write(json_quote)
-		b.Write(value)
+		b.WriteString(base64.StdEncoding.EncodeToString(value))
 		b.Write(json_quote)
+	default:
+		f.appendScalar(b, f.kind, value)
+	}
+}
+
+func (f *Field) appendScalar(b writer, kind string, value []byte) {
+	switch kind {
+	case "number":
+		if isJsonNumber(value) {
+			b.Write(value)
+			return
+		}
+	case "bool":
+		if v, ok := parseBool(value); ok {
+			b.WriteString(strconv.FormatBool(v))
+			return
+		}
+	case "time", "date", "timeofday":
+		if t, ok := parseTime(string(value)); ok {
+			b.Write(json_quote)
+			b.WriteString(t.Format(f.layout))
+			b.Write(json_quote)
+			return
+		}
+	}
+	appendJsonString(b, value)
+}
+
+// parseBool parses the boolean spellings of the supported databases.
+func parseBool(value []byte) (bool, bool) {
+	switch strings.ToLower(string(value)) {
+	case "t", "true", "y", "yes", "on", "1":
+		return true, true
+	case "f", "false", "n", "no", "off", "0":
+		return false, true
 	}
+	return false, false
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range parseLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isJsonNumber reports whether b is a number in json syntax, which rules out
+// values like NaN and Infinity.
+func isJsonNumber(b []byte) bool {
+	i := 0
+	if i < len(b) && b[i] == '-' {
+		i++
+	}
+	digits := func() int {
+		n := 0
+		for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+			i++
+			n++
+		}
+		return n
+	}
+	if i < len(b) && b[i] == '0' {
+		i++
+	} else if digits() == 0 {
+		return false
+	}
+	if i < len(b) && b[i] == '.' {
+		i++
+		if digits() == 0 {
+			return false
+		}
+	}
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') {
+		i++
+		if i < len(b) && (b[i] == '+' || b[i] == '-') {
+			i++
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+	return i == len(b)
+}
+
+// appendArray writes a postgres array literal such as {1,2,NULL} or
+// {{"a b","c"},{d,e}} as a json array. It reports false, having written
+// nothing, when value is not a valid array literal.
+func (f *Field) appendArray(b writer, value []byte) bool {
+	p := &arrayParser{field: f, value: value}
+	if i := bytes.IndexByte(value, '='); i >= 0 && len(value) > 0 && value[0] == '[' {
+		p.pos = i + 1
+	}
+	var out strings.Builder
+	if !p.parse(&out) || p.pos != len(value) {
+		return false
+	}
+	b.WriteString(out.String())
+	return true
+}
+
+type arrayParser struct {
+	field	*Field
+	value	[]byte
+	pos		int
+}
+
+func (p *arrayParser) parse(b writer) bool {
+	if p.pos >= len(p.value) || p.value[p.pos] != '{' {
+		return false
+	}
+	p.pos++
+	b.Write(json_bracket_1)
+	if p.pos < len(p.value) && p.value[p.pos] == '}' {
+		p.pos++
+		b.Write(json_bracket_2)
+		return true
+	}
+	for {
+		if p.pos >= len(p.value) {
+			return false
+		}
+		switch p.value[p.pos] {
+		case '{':
+			if !p.parse(b) {
+				return false
+			}
+		case '"':
+			elem, ok := p.quoted()
+			if !ok {
+				return false
+			}
+			p.field.appendElem(b, elem)
+		default:
+			start := p.pos
+			for p.pos < len(p.value) && p.value[p.pos] != ',' && p.value[p.pos] != '}' {
+				p.pos++
+			}
+			elem := bytes.TrimSpace(p.value[start:p.pos])
+			if strings.EqualFold(string(elem), "NULL") {
+				b.Write(json_null)
+			} else {
+				p.field.appendElem(b, elem)
+			}
+		}
+		if p.pos >= len(p.value) {
+			return false
+		}
+		switch p.value[p.pos] {
+		case ',':
+			p.pos++
+			b.Write(json_comma)
+		case '}':
+			p.pos++
+			b.Write(json_bracket_2)
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+func (p *arrayParser) quoted() ([]byte, bool) {
+	var elem []byte
+	for p.pos++; p.pos < len(p.value); p.pos++ {
+		switch c := p.value[p.pos]; c {
+		case '\\':
+			p.pos++
+			if p.pos < len(p.value) {
+				elem = append(elem, p.value[p.pos])
+			}
+		case '"':
+			p.pos++
+			return elem, true
+		default:
+			elem = append(elem, c)
+		}
+	}
+	return nil, false
+}
+
+func (f *Field) appendElem(b writer, elem []byte) {
+	if f.elem == "json" && json.Valid(elem) {
+		f.scratch.Reset()
+		json.Compact(&f.scratch, elem)
+		b.Write(f.scratch.Bytes())
+		return
+	}
+	f.appendScalar(b, f.elem, elem)
+}
+
+const hex = "0123456789abcdef"
+
+// appendJsonString writes s as a json string, escaping quotes, backslashes
+// and control characters and replacing invalid utf-8 with U+FFFD.
+func appendJsonString(b writer, s []byte) {
+	b.Write(json_quote)
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			b.Write(s[start:i])
+			switch c {
+			case '"', '\\':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case '\n':
+				b.WriteString(`\n`)
+			case '\r':
+				b.WriteString(`\r`)
+			case '\t':
+				b.WriteString(`\t`)
+			default:
+				b.WriteString(`\u00`)
+				b.WriteByte(hex[c>>4])
+				b.WriteByte(hex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b.Write(s[start:i])
+			b.WriteString(`\ufffd`)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b.Write(s[start:i])
+			fmt.Fprintf(b, `\u%04x`, r)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b.Write(s[start:])
+	b.Write(json_quote)
 }
\ No newline at end of file
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAppendArray(t *testing.T) {
+	tests := []struct {
+		elem	string
+		value	string
+		want	string
+	}{
+		{"number", `{}`, `[]`},
+		{"number", `{1,2,3}`, `[1,2,3]`},
+		{"number", `{1,NULL,null}`, `[1,null,null]`},
+		{"number", `{1.5,NaN,Infinity}`, `[1.5,"NaN","Infinity"]`},
+		{"string", `{a,b c, d }`, `["a","b c","d"]`},
+		{"string", `{"a,b","c\"d","e\\f","NULL"}`, `["a,b","c\"d","e\\f","NULL"]`},
+		{"string", `{"",""}`, `["",""]`},
+		{"number", `{{1,2},{3,4}}`, `[[1,2],[3,4]]`},
+		{"string", `{{"a b","c"},{d,e}}`, `[["a b","c"],["d","e"]]`},
+		{"bool", `{t,f,NULL}`, `[true,false,null]`},
+		{"json", `{"{\"a\": 1}","[1, 2]",x}`, `[{"a":1},[1,2],"x"]`},
+		{"number", `[0:2]={1,2,3}`, `[1,2,3]`},
+		{"number", `[1:1][1:2]={{1,2}}`, `[[1,2]]`},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		f := &Field{kind: "array", elem: tt.elem}
+		if !f.appendArray(&b, []byte(tt.value)) || b.String() != tt.want {
+			t.Errorf("appendArray(%s of %s) = %s, want %s", tt.value, tt.elem, b.String(), tt.want)
+		}
+	}
+}
+
+func TestAppendArrayInvalid(t *testing.T) {
+	for _, value := range []string{``, `1,2`, `{`, `{1,2`, `{1,2}x`, `{"a}`, `{{1,2}`, `{1}}`, `[0:1]`, `{{1}x}`} {
+		var b strings.Builder
+		f := &Field{kind: "array", elem: "number"}
+		if f.appendArray(&b, []byte(value)) || b.Len() != 0 {
+			t.Errorf("appendArray(%s) = true, %s, want false and nothing written", value, b.String())
+		}
+	}
+}
+
+func TestIsJsonNumber(t *testing.T) {
+	tests := []struct {
+		value	string
+		want	bool
+	}{
+		{"0", true},
+		{"-0", true},
+		{"42", true},
+		{"-3.25", true},
+		{"1e10", true},
+		{"1E+2", true},
+		{"2.5e-3", true},
+		{"0.0", true},
+		{"", false},
+		{"-", false},
+		{"01", false},
+		{"+1", false},
+		{".5", false},
+		{"5.", false},
+		{"1e", false},
+		{"1e+", false},
+		{"0x10", false},
+		{"NaN", false},
+		{"Infinity", false},
+		{"-Infinity", false},
+		{" 1", false},
+		{"1 ", false},
+	}
+	for _, tt := range tests {
+		if got := isJsonNumber([]byte(tt.value)); got != tt.want {
+			t.Errorf("isJsonNumber(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAppendJsonString(t *testing.T) {
+	tests := []struct {
+		value	string
+		want	string
+	}{
+		{"", `""`},
+		{"abc", `"abc"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"a\nb\rc\td", `"a\nb\rc\td"`},
+		{"\x00\x1f", `"\u0000\u001f"`},
+		{"<&>", `"<&>"`},
+		{"héllo 世界", `"héllo 世界"`},
+		{"a\xffb", `"a\ufffdb"`},
+		{"\xe4\xb8", `"\ufffd\ufffd"`},
+		{"a\u2028b\u2029", `"a\u2028b\u2029"`},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		appendJsonString(&b, []byte(tt.value))
+		if b.String() != tt.want {
+			t.Errorf("appendJsonString(%q) = %s, want %s", tt.value, b.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mediaTypes of the output types, in order of preference when a client
+// accepts several of them equally.
+var mediaTypes = []struct {
+	output		string
+	mediaType	string
+}{
+	{"list", "application/json"},
+	{"single", "application/json"},
+	{"nested", "application/json"},
+	{"page", "application/json"},
+	{"ndjson", "application/x-ndjson"},
+	{"csv", "text/csv"},
+	{"tsv", "text/tab-separated-values"},
+	{"xlsx", xlsx_content_type},
+	{"xml", "application/xml"},
+	{"xml", "text/xml"},
+}
+
+func mediaTypeOf(output string) string {
+	for _, m := range mediaTypes {
+		if m.output == output {
+			return m.mediaType
+		}
+	}
+	return ""
+}
+
+type acceptRange struct {
+	mediaType	string
+	q			float64
+}
+
+// parseAccept parses an Accept header into media ranges ordered by quality,
+// more specific ranges first when equal.
+func parseAccept(header string) []acceptRange {
+	ranges := make([]acceptRange, 0)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		r := acceptRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if r.mediaType == "" {
+			continue
+		}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+	return ranges
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func matchMediaType(pattern, mediaType string) bool {
+	if pattern == "*/*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, pattern[:len(pattern)-1])
+	}
+	return pattern == mediaType
+}
+
+// Negotiate picks the output type for the Accept header. The configured
+// output type def is kept whenever any media range accepts it, */* included,
+// so browsers get it. Otherwise the best accepted output type is picked. It
+// fails when none of the output types is acceptable.
+func Negotiate(header, def string) (string, error) {
+	if strings.TrimSpace(header) == "" {
+		return def, nil
+	}
+	ranges := parseAccept(header)
+	refused := func(mediaType string) bool {
+		for _, r := range ranges {
+			if r.q <= 0 && r.mediaType == mediaType {
+				return true
+			}
+		}
+		return false
+	}
+	if m := mediaTypeOf(def); !refused(m) {
+		for _, r := range ranges {
+			if r.q > 0 && matchMediaType(r.mediaType, m) {
+				return def, nil
+			}
+		}
+	}
+	for _, r := range ranges {
+		if r.q <= 0 {
+			continue
+		}
+		for _, m := range mediaTypes {
+			if matchMediaType(r.mediaType, m.mediaType) && !refused(m.mediaType) {
+				return m.output, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("none of the accepted media types is available: %s", header)
+}
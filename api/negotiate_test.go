@@ -0,0 +1,64 @@
+package api
+
+import (
+	"testing"
+)
+
+const browser_accept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header	string
+		def		string
+		want	string
+	}{
+		{"", "list", "list"},
+		{"*/*", "csv", "csv"},
+		{browser_accept, "list", "list"},
+		{browser_accept, "csv", "csv"},
+		{browser_accept, "single", "single"},
+		{"application/json", "list", "list"},
+		{"text/csv", "list", "csv"},
+		{"text/*", "list", "csv"},
+		{"application/xml;q=0.5, text/csv", "list", "csv"},
+		{"application/x-ndjson", "list", "ndjson"},
+		{"text/xml", "list", "xml"},
+		{"application/json;q=0, */*", "list", "ndjson"},
+		{"text/csv;q=0, */*", "csv", "list"},
+		{"application/json, text/csv", "csv", "csv"},
+		{"application/pdf, text/csv;q=0.1", "list", "csv"},
+	}
+	for _, tt := range tests {
+		got, err := Negotiate(tt.header, tt.def)
+		if err != nil || got != tt.want {
+			t.Errorf("Negotiate(%q, %q) = %q, %v, want %q", tt.header, tt.def, got, err, tt.want)
+		}
+	}
+}
+
+func TestNegotiateNotAcceptable(t *testing.T) {
+	for _, header := range []string{"application/pdf", "image/*", "*/*;q=0", "application/json;q=0"} {
+		if got, err := Negotiate(header, "list"); err == nil {
+			t.Errorf("Negotiate(%q) = %q, want an error", header, got)
+		}
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	ranges := parseAccept("text/*;q=0.5, */*;q=0.5, text/csv;q=0.5, application/json, bad;q=x")
+	want := []acceptRange{
+		{"application/json", 1},
+		{"bad", 1},
+		{"text/csv", 0.5},
+		{"text/*", 0.5},
+		{"*/*", 0.5},
+	}
+	if len(ranges) != len(want) {
+		t.Fatalf("parseAccept = %v, want %v", ranges, want)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Errorf("parseAccept[%d] = %v, want %v", i, ranges[i], want[i])
+		}
+	}
+}
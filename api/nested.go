@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"db2rest/db"
+	"fmt"
+	"strings"
+)
+
+// NestedOutput is a list folding consecutive rows with the same group_by
+// columns into one object, with the nest_columns of each row in a child
+// array named nest. Dotted field names such as customer.name are written
+// as nested objects.
+type NestedOutput struct {
+	*ListOutput
+	parent	*fieldNode
+	child	*fieldNode
+	keys	[]int
+	last	[][]byte
+	nulls	[]bool
+	wrote	bool
+}
+
+// fieldNode is a json object of the fields sharing a name prefix, or a
+// single field when index is not negative.
+type fieldNode struct {
+	name		string
+	index		int
+	children	[]*fieldNode
+}
+
+func NewNestedOutput(ctx *Context) *NestedOutput {
+	return &NestedOutput{ListOutput: NewListOutput(ctx)}
+}
+
+func (o *NestedOutput) Columns(cols []*sql.ColumnType) error {
+	o.fields = NewFields(o.ctx, cols)
+
+	index := make(map[string]int)
+	for i, col := range cols {
+		index[col.Name()] = i
+	}
+	nested := make(map[int]bool)
+	for _, name := range o.ctx.api.nestColumns {
+		i, ok := index[name]
+		if !ok {
+			return fmt.Errorf("nest column %s is not in the result", name)
+		}
+		nested[i] = true
+	}
+	o.keys = make([]int, len(o.ctx.api.groupBy))
+	for k, name := range o.ctx.api.groupBy {
+		i, ok := index[name]
+		if !ok {
+			return fmt.Errorf("group_by column %s is not in the result", name)
+		}
+		o.keys[k] = i
+	}
+
+	o.parent = &fieldNode{index: -1}
+	o.child = &fieldNode{index: -1}
+	for i, field := range o.fields {
+		if nested[i] {
+			o.child.add(strings.Split(field.name, "."), i)
+		} else {
+			o.parent.add(strings.Split(field.name, "."), i)
+		}
+	}
+	o.last = make([][]byte, len(o.keys))
+	o.nulls = make([]bool, len(o.keys))
+	return nil;
+}
+
+func (n *fieldNode) add(path []string, index int) {
+	if len(path) == 1 {
+		n.children = append(n.children, &fieldNode{name: path[0], index: index})
+		return
+	}
+	for _, c := range n.children {
+		if c.index < 0 && c.name == path[0] {
+			c.add(path[1:], index)
+			return
+		}
+	}
+	c := &fieldNode{name: path[0], index: -1}
+	n.children = append(n.children, c)
+	c.add(path[1:], index)
+}
+
+// null reports whether all the fields of the node are NULL in row.
+func (n *fieldNode) null(row []*db.Value) bool {
+	if n.index >= 0 {
+		return row[n.index].Null
+	}
+	for _, c := range n.children {
+		if !c.null(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendMembers writes the members of an object node, and reports whether
+// any was written.
+func (o *NestedOutput) appendMembers(n *fieldNode, row []*db.Value) bool {
+	f := false
+	for _, c := range n.children {
+		null := c.null(row)
+		if null && o.ctx.api.nulls == "omit" {
+			continue
+		}
+		if f {
+			o.stream.Write(json_comma)
+		}
+		f = true
+
+		appendJsonString(o.stream, []byte(c.name))
+		o.stream.Write(json_colon)
+		switch {
+		case null:
+			o.stream.Write(json_null)
+		case c.index >= 0:
+			o.fields[c.index].AppendJsonValue(o.stream, row[c.index].Bytes)
+		default:
+			o.stream.Write(json_brace_1)
+			o.appendMembers(c, row)
+			o.stream.Write(json_brace_2)
+		}
+	}
+	return f
+}
+
+// same reports whether row belongs to the object of the previous row, and
+// remembers its group_by columns otherwise.
+func (o *NestedOutput) same(row []*db.Value) bool {
+	same := o.stream.Rows() > 1
+	for k, i := range o.keys {
+		if same && (row[i].Null != o.nulls[k] || !bytes.Equal(row[i].Bytes, o.last[k])) {
+			same = false
+		}
+	}
+	if !same {
+		for k, i := range o.keys {
+			o.last[k] = append(o.last[k][:0], row[i].Bytes...)
+			o.nulls[k] = row[i].Null
+		}
+	}
+	return same
+}
+
+func (o *NestedOutput) Row(row []*db.Value) error {
+	if err := o.stream.Row(); err != nil {
+		return err
+	}
+	same := o.same(row)
+	if !same {
+		if o.stream.Rows() > 1 {
+			o.stream.Write(json_bracket_2)
+			o.stream.Write(json_brace_2)
+			o.stream.Write(json_comma)
+		} else {
+			o.stream.Write(json_bracket_1)
+		}
+		o.stream.Write(json_brace_1)
+		if o.appendMembers(o.parent, row) {
+			o.stream.Write(json_comma)
+		}
+		appendJsonString(o.stream, []byte(o.ctx.api.nest))
+		o.stream.Write(json_colon)
+		o.stream.Write(json_bracket_1)
+		o.wrote = false
+	}
+	if !o.child.null(row) {
+		if o.wrote {
+			o.stream.Write(json_comma)
+		}
+		o.stream.Write(json_brace_1)
+		o.appendMembers(o.child, row)
+		o.stream.Write(json_brace_2)
+		o.wrote = true
+	}
+	return o.stream.Sync()
+}
+
+func (o *NestedOutput) End() {
+	if o.stream.Started() {
+		o.stream.Write(json_bracket_2)
+		o.stream.Write(json_brace_2)
+	}
+	o.ListOutput.End()
+}
@@ -1,7 +1,7 @@
 package api
 
 import (
-	"sync"
+	"db2rest/db"
 	"fmt"
 	"strings"
 	"encoding/csv"
@@ -19,141 +19,225 @@ var json_null		= []byte("null")
 
 type ExecOutput struct {
 	ctx 			*Context
+	results			[]execResult
+}
+
+type execResult struct {
 	lastInsertId	int64
 	rowsAffected	int64
 }
 
-func (o *ExecOutput) SQL() (string, error) {
+func (o *ExecOutput) SQL() (string, []interface{}, error) {
 	return o.ctx.api.SQL(o.ctx);
 }
 
+func (o *ExecOutput) Statements() int {
+	return len(o.ctx.api.statements)
+}
+
+func (o *ExecOutput) Statement(i int) (string, []interface{}, error) {
+	return o.ctx.api.Statement(o.ctx, i)
+}
+
+func (o *ExecOutput) TxOptions() *sql.TxOptions {
+	return o.ctx.api.txOptions
+}
+
 func (o *ExecOutput) Columns(cols []*sql.ColumnType) error {
 	return nil;
 }
 
-func (o *ExecOutput) Row(row []*[]byte) error {
+func (o *ExecOutput) Row(row []*db.Value) error {
 	return nil;
 }
 
 func (o *ExecOutput) Error(err error) {
-	o.ctx.RespondError(500, err)
+	err = o.ctx.dbError(err)
+	o.ctx.RespondError(statusOf(err, 500), err)
 }
 
 func (o *ExecOutput) Affected(lastInsertId, rowsAffected int64) {
-	o.lastInsertId = lastInsertId
-	o.rowsAffected = rowsAffected
+	o.results = append(o.results, execResult{lastInsertId, rowsAffected})
 }
 
 func (o *ExecOutput) End() {
-	if o.lastInsertId > 0 {
-		s := fmt.Sprintf(`{"lastInsertId":%d,"rowsAffected":%d}`, o.lastInsertId, o.rowsAffected)
-		o.ctx.RespondJson(200, []byte(s))
-	} else {
-		s := fmt.Sprintf(`{"rowsAffected":%d}`, o.rowsAffected)
-		o.ctx.RespondJson(200, []byte(s))
+	if o.ctx.api.sqltype != "transaction" {
+		o.ctx.RespondJson(200, []byte(o.results[0].json()))
+		return
+	}
+
+	var total int64
+	var b strings.Builder
+	for i, r := range o.results {
+		if i > 0 {
+			b.Write(json_comma)
+		}
+		b.WriteString(r.json())
+		total += r.rowsAffected
 	}
+	s := fmt.Sprintf(`{"rowsAffected":%d,"statements":[%s]}`, total, b.String())
+	o.ctx.RespondJson(200, []byte(s))
+}
+
+func (r execResult) json() string {
+	if r.lastInsertId > 0 {
+		return fmt.Sprintf(`{"lastInsertId":%d,"rowsAffected":%d}`, r.lastInsertId, r.rowsAffected)
+	}
+	return fmt.Sprintf(`{"rowsAffected":%d}`, r.rowsAffected)
 }
 
 type ListOutput struct {
 	ctx 	*Context
 	fields	[]*Field
-	once	bool
-	buffer  *strings.Builder
+	stream	*Stream
 }
 
 func NewListOutput(ctx *Context) *ListOutput {
-	return &ListOutput{ctx: ctx}
+	return &ListOutput{ctx: ctx, stream: NewStream(ctx, "application/json")}
 }
 
-func (o *ListOutput) SQL() (string, error) {
+func (o *ListOutput) SQL() (string, []interface{}, error) {
 	return o.ctx.api.SQL(o.ctx);
 }
 
 func (o *ListOutput) Columns(cols []*sql.ColumnType) error {
-	o.fields = make([]*Field, len(cols))
-	for i, col := range cols {
-		o.fields[i] = NewField(o.ctx, col)
+	o.fields = NewFields(o.ctx, cols)
+	return nil;
+}
+
+func (o *ListOutput) Row(row []*db.Value) error {
+	if err := o.stream.Row(); err != nil {
+		return err
 	}
+	if o.stream.Rows() > 1 {
+		o.stream.Write(json_comma)
+	} else {
+		o.stream.Write(json_bracket_1)
+	}
+	appendJsonObject(o.stream, o.ctx, o.fields, row)
+	return o.stream.Sync()
+}
 
-	o.once = false
-	o.buffer = new(strings.Builder)
-	o.buffer.Write(json_bracket_1)
+func (o *ListOutput) Affected(lastInsertId, rowsAffected int64) {
 
-	return nil;
 }
 
-func (o *ListOutput) Row(row []*[]byte) error {
-	if o.once {
-		o.buffer.Write(json_comma)
+func (o *ListOutput) Error(err error) {
+	o.stream.Error(err)
+}
+
+func (o *ListOutput) End() {
+	if o.stream.Started() {
+		o.stream.Write(json_bracket_2)
+		o.stream.End()
+	} else {
+		o.ctx.RespondNotFound()
 	}
-	o.once = true
+}
 
-	o.buffer.Write(json_brace_1)
+func NewFields(ctx *Context, cols []*sql.ColumnType) []*Field {
+	fields := make([]*Field, len(cols))
+	for i, col := range cols {
+		fields[i] = NewField(ctx, col)
+	}
+	return fields
+}
+
+// appendJsonObject writes row as a json object, leaving out NULL columns
+// unless the endpoint asks for output_nulls = "null".
+func appendJsonObject(b writer, ctx *Context, fields []*Field, row []*db.Value) {
+	b.Write(json_brace_1)
 
 	f := false
 	for i := 0; i < len(row); i++ {
-		field := o.fields[i]
-		val := *row[i]
-		if len(val) > 0 {
-			if f {
-				o.buffer.Write(json_comma)
-			}
-			f = true
-
-			field.AppendJsonName(o.buffer)
-			o.buffer.Write(json_colon)
-			field.AppendJsonValue(o.buffer, val)
+		field := fields[i]
+		val := row[i]
+		if val.Null && ctx.api.nulls == "omit" {
+			continue
+		}
+		if f {
+			b.Write(json_comma)
+		}
+		f = true
+
+		field.AppendJsonName(b)
+		b.Write(json_colon)
+		if val.Null {
+			b.Write(json_null)
+		} else {
+			field.AppendJsonValue(b, val.Bytes)
 		}
 	}
-	o.buffer.Write(json_brace_2)
+	b.Write(json_brace_2)
+}
 
-	return nil;
+type NdjsonOutput struct {
+	ctx 	*Context
+	fields	[]*Field
+	stream	*Stream
 }
 
-func (o *ListOutput) Affected(lastInsertId, rowsAffected int64) {
+func NewNdjsonOutput(ctx *Context) *NdjsonOutput {
+	return &NdjsonOutput{ctx: ctx, stream: NewStream(ctx, "application/x-ndjson")}
+}
 
+func (o *NdjsonOutput) SQL() (string, []interface{}, error) {
+	return o.ctx.api.SQL(o.ctx);
 }
 
-func (o *ListOutput) Error(err error) {
-	o.ctx.RespondError(500, err)
+func (o *NdjsonOutput) Columns(cols []*sql.ColumnType) error {
+	o.fields = NewFields(o.ctx, cols)
+	return nil;
 }
 
-func (o *ListOutput) End() {
-	if o.once {
-		o.buffer.Write(json_bracket_2)
-		o.ctx.RespondJson(200, []byte(o.buffer.String()))
-	} else {
-		o.ctx.RespondNotFound()
+func (o *NdjsonOutput) Row(row []*db.Value) error {
+	if err := o.stream.Row(); err != nil {
+		return err
 	}
+	appendJsonObject(o.stream, o.ctx, o.fields, row)
+	o.stream.WriteByte('\n')
+	return o.stream.Sync()
+}
+
+func (o *NdjsonOutput) Affected(lastInsertId, rowsAffected int64) {
+
+}
+
+func (o *NdjsonOutput) Error(err error) {
+	o.stream.Error(err)
+}
+
+func (o *NdjsonOutput) End() {
+	o.stream.Start()
+	o.stream.End()
 }
 
 type SingleOutput struct {
-	list	*ListOutput
-	once	sync.Once
+	ctx 	*Context
+	fields	[]*Field
+	once	bool
+	buffer  *strings.Builder
 }
 
 func NewSingleOutput(ctx *Context) *SingleOutput {
-	return &SingleOutput{list: NewListOutput(ctx)}
+	return &SingleOutput{ctx: ctx}
 }
 
-func (o *SingleOutput) SQL() (string, error) {
-	return o.list.ctx.api.SQL(o.list.ctx);
+func (o *SingleOutput) SQL() (string, []interface{}, error) {
+	return o.ctx.api.SQL(o.ctx);
 }
 
 func (o *SingleOutput) Columns(cols []*sql.ColumnType) error {
-	o.list.fields = make([]*Field, len(cols))
-	for i, col := range cols {
-		o.list.fields[i] = NewField(o.list.ctx, col)
-	}
-
-	o.list.once = false
-	o.list.buffer = new(strings.Builder)
+	o.fields = NewFields(o.ctx, cols)
+	o.once = false
+	o.buffer = new(strings.Builder)
 	return nil;
 }
 
-func (o *SingleOutput) Row(row []*[]byte) error {
-	if !o.list.once {
-		return o.list.Row(row)
+func (o *SingleOutput) Row(row []*db.Value) error {
+	if !o.once {
+		o.once = true
+		appendJsonObject(o.buffer, o.ctx, o.fields, row)
 	}
 	return nil
 }
@@ -163,31 +247,54 @@ func (o *SingleOutput) Affected(lastInsertId, rowsAffected int64) {
 }
 
 func (o *SingleOutput) Error(err error) {
-	o.list.ctx.RespondError(500, err)
+	err = o.ctx.dbError(err)
+	o.ctx.RespondError(statusOf(err, 500), err)
 }
 
 func (o *SingleOutput) End() {
-	if o.list.once {
-		o.list.ctx.RespondJson(200, []byte(o.list.buffer.String()))
+	if o.once {
+		o.ctx.RespondJson(200, []byte(o.buffer.String()))
 	} else {
-		o.list.ctx.RespondNotFound()
+		o.ctx.RespondNotFound()
 	}
 }
 
+var utf8_bom = []byte("\xef\xbb\xbf")
+
 type CsvOutput struct {
-	ctx 	*Context
- 	w 		*csv.Writer
+	ctx 		*Context
+ 	w 			*csv.Writer
+	stream		*Stream
+	delimiter	rune
+	ext			string
+	bom			bool
 }
 
 func NewCsvOutput(ctx *Context) *CsvOutput {
-	return &CsvOutput{ctx: ctx}
+	o := &CsvOutput{ctx: ctx, stream: NewStream(ctx, "text/csv"), ext: ".csv"}
+	o.delimiter = ctx.api.csvDelimiter
+	return o
+}
+
+func NewTsvOutput(ctx *Context) *CsvOutput {
+	o := &CsvOutput{ctx: ctx, stream: NewStream(ctx, "text/tab-separated-values"), ext: ".tsv"}
+	o.delimiter = '\t'
+	return o
 }
 
+// Write writes the csv to the response, after a byte order mark if the
+// endpoint asks for csv_bom.
 func (o *CsvOutput) Write(p []byte) (n int, err error) {
-	return o.ctx.response.Write(p)
+	if o.ctx.api.csvBom && !o.bom {
+		o.bom = true
+		if _, err := o.stream.Write(utf8_bom); err != nil {
+			return 0, err
+		}
+	}
+	return o.stream.Write(p)
 }
 
-func (o *CsvOutput) SQL() (string, error) {
+func (o *CsvOutput) SQL() (string, []interface{}, error) {
 	return o.ctx.api.SQL(o.ctx);
 }
 
@@ -197,28 +304,48 @@ func (o *CsvOutput) Columns(cols []*sql.ColumnType) error {
 		fields[i] = NewCsvField(o.ctx, col).name
 	}
 
-	filename := o.ctx.Param("filename")
-	if filename == "" {
-		filename = o.ctx.request.URL.EscapedPath()
-	}
-	o.ctx.response.Header().Add("Content-Disposition", `attachment; filename="` + filename + `.csv"`)
+	attachment(o.ctx, o.ext)
 
 	o.w = csv.NewWriter(o)
-	if err := o.w.Write(fields); err != nil {
-		return err
+	o.w.Comma = o.delimiter
+	o.w.UseCRLF = o.ctx.api.csvCrlf
+	if o.ctx.api.csvHeader {
+		if err := o.w.Write(fields); err != nil {
+			return err
+		}
 	}
 	return nil;
 }
 
-func (o *CsvOutput) Row(row []*[]byte) error {
+// attachment names the downloaded file by the filename param, or else by
+// the request path.
+func attachment(ctx *Context, ext string) {
+	filename := ctx.Param("filename")
+	if filename == "" {
+		filename = ctx.request.URL.EscapedPath()
+	}
+	ctx.response.Header().Add("Content-Disposition", `attachment; filename="` + filename + ext + `"`)
+}
+
+func (o *CsvOutput) Row(row []*db.Value) error {
+	if err := o.stream.Row(); err != nil {
+		return err
+	}
 	fields := make([]string, len(row))
 	for i := 0; i < len(row); i++ {
-		fields[i] = string(*row[i])
+		if row[i].Null {
+			fields[i] = o.ctx.api.csvNull
+		} else {
+			fields[i] = string(row[i].Bytes)
+		}
 	}
 	if err := o.w.Write(fields); err != nil {
 		return err
 	}
-	return nil;
+	if o.stream.Due() {
+		o.w.Flush()
+	}
+	return o.stream.Sync()
 }
 
 func (o *CsvOutput) Affected(lastInsertId, rowsAffected int64) {
@@ -226,12 +353,19 @@ func (o *CsvOutput) Affected(lastInsertId, rowsAffected int64) {
 }
 
 func (o *CsvOutput) Error(err error) {
-	o.ctx.RespondError(500, err)
+	if o.stream.Started() {
+		o.w.Flush()
+	} else {
+		o.ctx.response.Header().Del("Content-Disposition")
+	}
+	o.stream.Error(err)
 }
 
 func (o *CsvOutput) End() {
 	o.w.Flush()
 	if err := o.w.Error(); err != nil {
-		o.ctx.RespondError(500, err)
+		o.stream.Error(err)
+		return
 	}
+	o.stream.End()
 }
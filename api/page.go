@@ -0,0 +1,213 @@
+package api
+
+import (
+	"database/sql"
+	"db2rest/db"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cursor is the position of the next page, sent to clients base64 encoded.
+type cursor struct {
+	Offset	int64		`json:"offset,omitempty"`
+	After	[]string	`json:"after,omitempty"`
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return c, nil
+}
+
+func (c *cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PageOutput is a page of the rows of the query, selected by the offset or
+// keyset of the endpoint's paginate config and wrapped in an envelope with
+// the cursor of the next page.
+type PageOutput struct {
+	*ListOutput
+	size	int64
+	page	int64
+	offset	int64
+	after	[]string
+	keys	[]int
+	last	[]string
+	total	int64
+	counted	bool
+	more	bool
+}
+
+func NewPageOutput(ctx *Context) (*PageOutput, error) {
+	o := &PageOutput{ListOutput: NewListOutput(ctx), page: 1}
+	api := ctx.api
+
+	o.size = int64(api.pageSize)
+	if s := ctx.Param("size"); s != "" {
+		size, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("param size is invalid")
+		}
+		o.size = size
+	}
+	if o.size > int64(api.maxPageSize) {
+		o.size = int64(api.maxPageSize)
+	}
+
+	if s := ctx.Param("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		if c.Offset < 0 || api.paginate == "keyset" && len(c.After) != len(api.keyset) {
+			return nil, errors.New("invalid cursor")
+		}
+		o.offset = c.Offset
+		o.after = c.After
+	} else if s := ctx.Param("page"); s != "" && api.paginate == "offset" {
+		page, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || page <= 0 {
+			return nil, fmt.Errorf("param page is invalid")
+		}
+		o.offset = (page - 1) * o.size
+	}
+	o.page = o.offset / o.size + 1
+	return o, nil
+}
+
+// SQL selects one more row than the page size from the query of the
+// endpoint, to know if there is a next page.
+func (o *PageOutput) SQL() (string, []interface{}, error) {
+	sql, args, err := o.ctx.api.SQL(o.ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return o.ctx.api.db.Placeholder(len(args))
+	}
+
+	var b strings.Builder
+	b.WriteString("select * from (" + sql + "\n) _page")
+	if o.ctx.api.paginate == "keyset" {
+		cols := strings.Join(o.ctx.api.keyset, ", ")
+		if len(o.after) > 0 {
+			params := make([]string, len(o.after))
+			for i, v := range o.after {
+				params[i] = bind(v)
+			}
+			op := ">"
+			if o.ctx.api.keysetDesc {
+				op = "<"
+			}
+			b.WriteString(" where (" + cols + ") " + op + " (" + strings.Join(params, ", ") + ")")
+		}
+		b.WriteString(" order by " + cols)
+		if o.ctx.api.keysetDesc {
+			b.WriteString(" desc")
+		}
+		b.WriteString(" limit " + bind(o.size + 1))
+	} else {
+		b.WriteString(" limit " + bind(o.size + 1))
+		b.WriteString(" offset " + bind(o.offset))
+	}
+	return b.String(), args, nil
+}
+
+func (o *PageOutput) CountSQL() (string, []interface{}, error) {
+	if !o.ctx.api.pageTotal {
+		return "", nil, nil
+	}
+	sql, args, err := o.ctx.api.SQL(o.ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return "select count(*) from (" + sql + "\n) _count", args, nil
+}
+
+func (o *PageOutput) Count(total int64) {
+	o.total = total
+	o.counted = true
+}
+
+func (o *PageOutput) Columns(cols []*sql.ColumnType) error {
+	if err := o.ListOutput.Columns(cols); err != nil {
+		return err
+	}
+	o.keys = make([]int, len(o.ctx.api.keyset))
+	for k, name := range o.ctx.api.keyset {
+		o.keys[k] = -1
+		for i, col := range cols {
+			if col.Name() == name {
+				o.keys[k] = i
+			}
+		}
+		if o.keys[k] < 0 {
+			return fmt.Errorf("keyset column %s is not in the result", name)
+		}
+	}
+	o.last = make([]string, len(o.keys))
+	return nil
+}
+
+func (o *PageOutput) Row(row []*db.Value) error {
+	if int64(o.stream.Rows()) >= o.size {
+		o.more = true
+		return nil
+	}
+	// a null key can not be compared with, so it can not start the next page
+	for k, i := range o.keys {
+		if row[i].Null {
+			return fmt.Errorf("keyset column %s is null", o.ctx.api.keyset[k])
+		}
+	}
+	if err := o.stream.Row(); err != nil {
+		return err
+	}
+	if o.stream.Rows() > 1 {
+		o.stream.Write(json_comma)
+	} else {
+		o.stream.WriteString(`{"items":[`)
+	}
+	appendJsonObject(o.stream, o.ctx, o.fields, row)
+	for k, i := range o.keys {
+		o.last[k] = string(row[i].Bytes)
+	}
+	return o.stream.Sync()
+}
+
+func (o *PageOutput) End() {
+	if !o.stream.Started() {
+		o.stream.WriteString(`{"items":[`)
+	}
+	o.stream.WriteString(`],"size":` + strconv.FormatInt(o.size, 10))
+	if o.ctx.api.paginate == "offset" {
+		o.stream.WriteString(`,"page":` + strconv.FormatInt(o.page, 10))
+	}
+	if o.counted {
+		o.stream.WriteString(`,"total":` + strconv.FormatInt(o.total, 10))
+	}
+	if o.more {
+		next := &cursor{Offset: o.offset + o.size}
+		if o.ctx.api.paginate == "keyset" {
+			next = &cursor{After: o.last}
+		}
+		o.stream.WriteString(`,"next":"` + next.String() + `"`)
+	} else {
+		o.stream.WriteString(`,"next":null`)
+	}
+	o.stream.Write(json_brace_2)
+	o.stream.End()
+}
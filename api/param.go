@@ -3,16 +3,93 @@ package api
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 type Param struct {
 	name 	string
+	source	string
+	key		string
+	typ		string
+	array	bool
 	validators 	[]Validator
+	codes		[]string
+}
+
+// Parse reads the options of a param: its source, type, array flag and
+// validators.
+func (p *Param) Parse(config ...string) error {
+	validators := make([]string, 0, len(config))
+	for _, s := range config {
+		if s == "array" {
+			p.array = true
+			continue
+		}
+		parts := strings.SplitN(s, ":", 2)
+		if parts[0] != "source" && parts[0] != "type" {
+			validators = append(validators, s)
+			continue
+		}
+		if len(parts) < 2 {
+			return fmt.Errorf("invalid config: %s", s)
+		}
+		if parts[0] == "type" {
+			if _, ok := coercers[parts[1]]; !ok {
+				return fmt.Errorf("invalid type: %s", parts[1])
+			}
+			p.typ = parts[1]
+		} else if err := p.ParseSource(parts[1]); err != nil {
+			return err
+		}
+	}
+	return p.ParseValidators(validators...)
+}
+
+// ParseSource reads the only source the value of the param is taken from:
+// path, query, body, form, header:<name> or cookie:<name>. The header or
+// cookie name defaults to the param name.
+func (p *Param) ParseSource(config string) error {
+	parts := strings.SplitN(config, ":", 2)
+	switch parts[0] {
+	case "path", "query", "body", "form":
+		if len(parts) > 1 {
+			return fmt.Errorf("invalid source: %s", config)
+		}
+	case "header", "cookie":
+		p.key = p.name
+		if len(parts) > 1 && parts[1] != "" {
+			p.key = parts[1]
+		}
+	default:
+		return fmt.Errorf("invalid source: %s", config)
+	}
+	p.source = parts[0]
+	return nil
+}
+
+// Lookup returns the value of the param from its source.
+func (p *Param) Lookup(c *Context) (interface{}, bool) {
+	switch p.source {
+	case "header":
+		if v := c.request.Header.Get(p.key); v != "" {
+			return v, true
+		}
+	case "cookie":
+		if cookie, err := c.request.Cookie(p.key); err == nil {
+			return cookie.Value, true
+		}
+	default:
+		v, ok := c.sources[p.source][p.name]
+		return v, ok
+	}
+	return nil, false
 }
 
 func (p *Param) ParseValidators(config ...string) error {
 	p.validators = make([]Validator, len(config))
+	p.codes = make([]string, len(config))
 	for i, s := range config {
+		p.codes[i] = strings.SplitN(s, ":", 2)[0]
 		parts := strings.SplitN(s, ":", 2)
 
 		switch parts[0] {
@@ -20,6 +97,26 @@ func (p *Param) ParseValidators(config ...string) error {
 			p.validators[i] = &requiredValidator{}
 		case "pattern":
 			p.validators[i] = &patternValidator{}
+		case "min":
+			p.validators[i] = &boundValidator{}
+		case "max":
+			p.validators[i] = &boundValidator{max: true}
+		case "minlen":
+			p.validators[i] = &lengthValidator{}
+		case "maxlen":
+			p.validators[i] = &lengthValidator{max: true}
+		case "enum":
+			p.validators[i] = &enumValidator{sep: "|"}
+		case "oneof":
+			p.validators[i] = &enumValidator{sep: ","}
+		case "email", "uuid", "int", "number":
+			p.validators[i] = &formatValidator{format: parts[0]}
+		case "date":
+			p.validators[i] = &dateValidator{}
+		case "minitems":
+			p.validators[i] = &itemsValidator{}
+		case "maxitems":
+			p.validators[i] = &itemsValidator{max: true}
 		default:
 			return fmt.Errorf("invalid config: %s", s)
 		}
@@ -36,12 +133,95 @@ func (p *Param) ParseValidators(config ...string) error {
 	return nil
 }
 
-func (p *Param) Validate(c *Context) error {
-	v := c.Param(p.name)
-	for _, validator := range p.validators {
-		if err := validator.Validate(p, v); err != nil {
+// Coerce converts the value of the param to its type, and fails with a type
+// error when it is not a value of the type.
+// An array param takes a single value as a list of one item, and converts
+// each item.
+func (p *Param) Coerce(c *Context) *FieldError {
+	v, ok := c.values[p.name]
+	if !ok || v == nil {
+		return nil
+	}
+	if !p.array {
+		typed, err := p.coerce(v)
+		if err != nil {
+			return err
+		}
+		c.values[p.name] = typed
+		return nil
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+	items := make([]interface{}, len(list))
+	for i, item := range list {
+		typed, err := p.coerce(item)
+		if err != nil {
 			return err
 		}
+		items[i] = typed
+	}
+	c.values[p.name] = items
+	return nil
+}
+
+// coerce converts v to the type of the param. An empty value of a type other
+// than string is nil, bound as NULL.
+func (p *Param) coerce(v interface{}) (interface{}, *FieldError) {
+	if p.typ == "" || p.typ == "string" && v == "" || v == nil {
+		return v, nil
+	}
+	if v == "" {
+		return nil, nil
+	}
+	typed, ok := coercers[p.typ](v)
+	if !ok {
+		return nil, &FieldError{Field: p.name, Code: "type", Message: fmt.Sprintf("param %s is not a valid %s", p.name, p.typ)}
+	}
+	return typed, nil
+}
+
+// Format formats a value of the param as text.
+func (p *Param) Format(v interface{}) string {
+	if t, ok := v.(time.Time); ok && p.typ == "date" {
+		return t.Format(date_layout)
+	}
+	return formatValue(v)
+}
+
+// Validate checks the value of the param, or each item of an array param,
+// and returns the first failure. An empty array is checked as an empty value.
+func (p *Param) Validate(c *Context) *FieldError {
+	if !p.array {
+		return p.validate(c.Param(p.name))
+	}
+
+	list, _ := c.Value(p.name).([]interface{})
+	for i, validator := range p.validators {
+		if vd, ok := validator.(ItemsValidator); ok {
+			if err := vd.ValidateItems(p, len(list)); err != nil {
+				return &FieldError{Field: p.name, Code: p.codes[i], Message: err.Error()}
+			}
+		}
+	}
+	if len(list) == 0 {
+		return p.validate("")
+	}
+	for _, item := range list {
+		if err := p.validate(p.Format(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Param) validate(v string) *FieldError {
+	for i, validator := range p.validators {
+		if err := validator.Validate(p, v); err != nil {
+			return &FieldError{Field: p.name, Code: p.codes[i], Message: err.Error()}
+		}
 	}
 	return nil
 }
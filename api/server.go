@@ -1,6 +1,7 @@
 package api
 
 import (
+	"db2rest/auth"
 	"db2rest/conf"
 	"db2rest/db"
 	"os"
@@ -16,7 +17,8 @@ import (
 
 type Server struct {
 	conf 	*conf.Conf
-	db		*db.Client
+	dbs		map[string]*db.Client
+	apis	map[string]*Endpoint
 	server 	*http.Server
 }
 
@@ -25,13 +27,12 @@ func NewServer(conf *conf.Conf) *Server {
 }
 
 func (svr *Server) Start() error {
-	db, err := db.New(svr.conf)
-	if err != nil {
+	if err := svr.InitDatasources(); err != nil {
 		return err
 	}
-	svr.db = db
 
 	var router = mux.NewRouter()
+	svr.apis = make(map[string]*Endpoint)
 	it, err := svr.conf.Iterator("api")
 	if err != nil {
 		return err
@@ -41,20 +42,30 @@ func (svr *Server) Start() error {
 		if err != nil {
 			return err
 		}
-		e, err := NewEndpoint(api, db)
+		name := api.GetString("datasource", "")
+		db, ok := svr.dbs[name]
+		if !ok {
+			return fmt.Errorf("datasource %s of api %s is not configured", name, api.GetString("url", ""))
+		}
+		e, err := NewEndpoint(api, svr.conf, db)
 		if err != nil {
 			return err
 		}
 		log.Printf("deployed: %s %s\n", e.method, e.url)
-		router.HandleFunc(e.url, e.Handle).Methods(e.method)
+		route := e.method + " " + e.url
+		svr.apis[route] = e
+		router.HandleFunc(e.url, e.Handle).Methods(e.method).Name(route)
+	}
+	if err := svr.InitAuth(router); err != nil {
+		return err
 	}
 
 	addr := fmt.Sprintf("%s:%d", svr.conf.GetString("host", ""), svr.conf.GetInt("port", 3424))
 	svr.server = &http.Server{
         Addr:         	addr,
-        // WriteTimeout: 	time.Second * 15,
-        // ReadTimeout:  	time.Second * 15,
-        // IdleTimeout:  	time.Second * 15,
+        WriteTimeout: 	time.Second * time.Duration(svr.conf.GetInt("write_timeout_seconds", 0)),
+        ReadTimeout:  	time.Second * time.Duration(svr.conf.GetInt("read_timeout_seconds", 15)),
+        IdleTimeout:  	time.Second * time.Duration(svr.conf.GetInt("idle_timeout_seconds", 60)),
         Handler: 		router,
     }
 
@@ -68,6 +79,87 @@ func (svr *Server) Start() error {
 	return nil
 }
 
+// InitAuth requires api keys to call the apis when [auth] is configured,
+// unless they are public. Without [auth], apis restricted by scopes, roles
+// or claims fail to start rather than being served open.
+func (svr *Server) InitAuth(router *mux.Router) error {
+	keys, err := svr.conf.Keys("auth")
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		for _, e := range svr.apis {
+			if e.rule.Restricted() {
+				return fmt.Errorf("api %s %s requires scopes, roles or claims but [auth] is not configured", e.method, e.url)
+			}
+		}
+		return nil
+	}
+	ac, err := svr.conf.Get("auth")
+	if err != nil {
+		return err
+	}
+	k, err := auth.New(ac)
+	if err != nil {
+		return err
+	}
+	router.Use(k.Middleware(svr.rule))
+	return nil
+}
+
+// rule returns the authorization rule of the api matching r.
+func (svr *Server) rule(r *http.Request) *auth.Rule {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil
+	}
+	if e, ok := svr.apis[route.GetName()]; ok {
+		return e.rule
+	}
+	return nil
+}
+
+// InitDatasources opens the unnamed datasource configured by [db] and a
+// named one for each [db.<name>] table.
+func (svr *Server) InitDatasources() error {
+	svr.dbs = make(map[string]*db.Client)
+	dc, err := svr.conf.Get("db")
+	if err != nil {
+		return err
+	}
+	keys, err := dc.Keys("_")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := dc.Keys(key); err != nil {
+			continue
+		}
+		c, err := dc.Get(key)
+		if err != nil {
+			return err
+		}
+		if err := svr.openDatasource(key, c); err != nil {
+			return err
+		}
+	}
+	if dc.GetString("url", "") != "" || len(svr.dbs) == 0 {
+		if err := svr.openDatasource("", dc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (svr *Server) openDatasource(name string, conf *conf.Conf) error {
+	c, err := db.New(name, conf)
+	if err != nil {
+		return err
+	}
+	svr.dbs[name] = c
+	return nil
+}
+
 func (svr *Server) Run(signals ...os.Signal) error {
 	if err  := svr.Start(); err != nil {
 		return err
@@ -92,6 +184,8 @@ func (svr *Server) Run(signals ...os.Signal) error {
 
 func (svr *Server) Shutdown(ctx context.Context) {
 	svr.server.Shutdown(ctx)
-	svr.db.Close()
+	for _, c := range svr.dbs {
+		c.Close()
+	}
 }
 
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// trailer_error is the http trailer reporting an error that happened after
+// the response status has been sent.
+const trailer_error = "X-Stream-Error"
+
+type writer interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// Stream writes a response body to the client as rows arrive. The status and
+// headers are only sent on Start, so that an error before the first row
+// still gets a regular error response.
+type Stream struct {
+	ctx			*Context
+	contentType	string
+	w			*bufio.Writer
+	rows		int
+}
+
+func NewStream(ctx *Context, contentType string) *Stream {
+	return &Stream{ctx: ctx, contentType: contentType}
+}
+
+func (s *Stream) Started() bool {
+	return s.w != nil
+}
+
+func (s *Stream) Start() {
+	if s.w != nil {
+		return
+	}
+	header := s.ctx.response.Header()
+	header.Set("Content-Type", s.contentType)
+	header.Set("Trailer", trailer_error)
+	s.ctx.response.WriteHeader(200)
+	s.w = bufio.NewWriter(s.ctx.response)
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	s.Start()
+	return s.w.Write(p)
+}
+
+func (s *Stream) WriteByte(c byte) error {
+	s.Start()
+	return s.w.WriteByte(c)
+}
+
+func (s *Stream) WriteString(str string) (int, error) {
+	s.Start()
+	return s.w.WriteString(str)
+}
+
+// Row counts a row about to be written. It fails when the row limit of the
+// endpoint is exceeded.
+func (s *Stream) Row() error {
+	s.rows++
+	if max := s.ctx.api.maxRows; max > 0 && s.rows > max {
+		return fmt.Errorf("row limit %d exceeded", max)
+	}
+	return nil
+}
+
+// Due reports whether the rows written so far should be flushed to the
+// client, which happens every flush_rows rows.
+func (s *Stream) Due() bool {
+	return s.rows % s.ctx.api.flushRows == 0
+}
+
+// Sync flushes the rows written so far when due.
+func (s *Stream) Sync() error {
+	if s.Due() {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *Stream) Rows() int {
+	return s.rows
+}
+
+func (s *Stream) Flush() error {
+	if s.w == nil {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if f, ok := s.ctx.response.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// Error responds with err if nothing has been sent yet. Otherwise the body
+// is left unterminated, so clients can not mistake it for a complete
+// document, and err is reported in the X-Stream-Error trailer.
+func (s *Stream) Error(err error) {
+	err = s.ctx.dbError(err)
+	if s.w == nil {
+		s.ctx.RespondError(statusOf(err, 500), err)
+		return
+	}
+	log.Printf("error after %d rows: %v\n", s.rows, err)
+	if err := s.Flush(); err != nil {
+		log.Printf("fail to flush: %v\n", err)
+	}
+	s.ctx.response.Header().Set(trailer_error, err.Error())
+}
+
+func (s *Stream) End() {
+	if err := s.Flush(); err != nil {
+		log.Printf("fail to flush: %v\n", err)
+	}
+}
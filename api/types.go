@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var uuid_regex = regexp.MustCompile(`^(?i)(urn:uuid:)?\{?([0-9a-f]{8})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{12})\}?$`)
+
+const date_layout = "2006-01-02"
+
+var timestamp_layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// coercers convert request values to the declared type of a param, for
+// which they are exposed to templates and bound to sql.
+var coercers = map[string]func(v interface{}) (interface{}, bool){
+	"string":		coerceString,
+	"int":			coerceInt,
+	"float":		coerceFloat,
+	"bool":			coerceBool,
+	"date":			coerceDate,
+	"timestamp":	coerceTimestamp,
+	"uuid":			coerceUuid,
+	"json":			coerceJson,
+}
+
+func coerceString(v interface{}) (interface{}, bool) {
+	return formatValue(v), true
+}
+
+func coerceInt(v interface{}) (interface{}, bool) {
+	s := formatValue(v)
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) || math.Abs(f) >= 1<<63 {
+		return nil, false
+	}
+	return int64(f), true
+}
+
+func coerceFloat(v interface{}) (interface{}, bool) {
+	f, err := strconv.ParseFloat(formatValue(v), 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, false
+	}
+	return f, true
+}
+
+func coerceBool(v interface{}) (interface{}, bool) {
+	if b, ok := v.(bool); ok {
+		return b, true
+	}
+	return parseBool([]byte(formatValue(v)))
+}
+
+func coerceDate(v interface{}) (interface{}, bool) {
+	t, err := time.Parse(date_layout, formatValue(v))
+	if err != nil {
+		return nil, false
+	}
+	return t, true
+}
+
+func coerceTimestamp(v interface{}) (interface{}, bool) {
+	s := formatValue(v)
+	for _, layout := range timestamp_layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func coerceUuid(v interface{}) (interface{}, bool) {
+	m := uuid_regex.FindStringSubmatch(formatValue(v))
+	if m == nil {
+		return nil, false
+	}
+	return strings.ToLower(strings.Join(m[2:], "-")), true
+}
+
+// coerceJson takes json values of a json body as they are, and strings of
+// other sources as json text.
+func coerceJson(v interface{}) (interface{}, bool) {
+	if s, ok := v.(string); ok {
+		var b bytes.Buffer
+		if err := json.Compact(&b, []byte(s)); err != nil {
+			return nil, false
+		}
+		return json.RawMessage(b.Bytes()), true
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	return json.RawMessage(b), true
+}
+
+// formatValue formats a request value as text, writing numbers without
+// exponent, times in RFC 3339 and lists separated by commas.
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return strconv.FormatInt(i, 10)
+		}
+		if f, err := t.Float64(); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return t.String()
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case bool:
+		return strconv.FormatBool(t)
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case json.RawMessage:
+		return string(t)
+	case []interface{}:
+		items := make([]string, len(t))
+		for i, item := range t {
+			items[i] = formatValue(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(t)
+	}
+}
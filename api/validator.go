@@ -4,7 +4,12 @@ import (
 	"regexp"
 	"errors"
 	"fmt"
+	"math"
+	"net/mail"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type Validator interface {
@@ -12,6 +17,12 @@ type Validator interface {
 	Init(config string) error
 }
 
+// ItemsValidator is a Validator checking the number of items of an array
+// param, rather than each item.
+type ItemsValidator interface {
+	ValidateItems(p *Param, n int) error
+}
+
 type requiredValidator struct {
 	required	bool
 }
@@ -61,3 +72,174 @@ func (vd *patternValidator) Validate(p *Param, v string) error {
 	return nil
 }
 
+
+// boundValidator checks a number is not below min, or not above max.
+type boundValidator struct {
+	max		bool
+	bound	float64
+	config	string
+}
+
+func (vd *boundValidator) Init(config string) (err error) {
+	vd.config = config
+	if vd.bound, err = strconv.ParseFloat(config, 64); err != nil {
+		return fmt.Errorf("invalid config: %s", config)
+	}
+	return nil
+}
+
+func (vd *boundValidator) Validate(p *Param, v string) error {
+	if v == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("param %s is not a valid number", p.name)
+	}
+	if vd.max && f > vd.bound {
+		return fmt.Errorf("param %s must be at most %s", p.name, vd.config)
+	}
+	if !vd.max && f < vd.bound {
+		return fmt.Errorf("param %s must be at least %s", p.name, vd.config)
+	}
+	return nil
+}
+
+// lengthValidator checks the number of characters of a value.
+type lengthValidator struct {
+	max		bool
+	length	int
+}
+
+func (vd *lengthValidator) Init(config string) (err error) {
+	if vd.length, err = strconv.Atoi(config); err != nil || vd.length < 0 {
+		return fmt.Errorf("invalid config: %s", config)
+	}
+	return nil
+}
+
+func (vd *lengthValidator) Validate(p *Param, v string) error {
+	if v == "" {
+		return nil
+	}
+	n := utf8.RuneCountInString(v)
+	if vd.max && n > vd.length {
+		return fmt.Errorf("param %s must be at most %d characters", p.name, vd.length)
+	}
+	if !vd.max && n < vd.length {
+		return fmt.Errorf("param %s must be at least %d characters", p.name, vd.length)
+	}
+	return nil
+}
+
+// enumValidator checks a value is one of a list, separated by sep in the
+// config: | for enum and , for oneof.
+type enumValidator struct {
+	sep		string
+	values	[]string
+}
+
+func (vd *enumValidator) Init(config string) error {
+	if config == "" {
+		return errors.New("invalid enum config")
+	}
+	vd.values = strings.Split(config, vd.sep)
+	return nil
+}
+
+func (vd *enumValidator) Validate(p *Param, v string) error {
+	if v == "" {
+		return nil
+	}
+	for _, value := range vd.values {
+		if v == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("param %s must be one of %s", p.name, strings.Join(vd.values, ", "))
+}
+
+// formatValidator checks a value is of a format: email, uuid, int or number.
+type formatValidator struct {
+	format	string
+}
+
+func (vd *formatValidator) Init(config string) error {
+	if config != "" {
+		return fmt.Errorf("invalid config: %s", config)
+	}
+	return nil
+}
+
+func (vd *formatValidator) Validate(p *Param, v string) error {
+	if v == "" {
+		return nil
+	}
+	var ok bool
+	switch vd.format {
+	case "email":
+		a, err := mail.ParseAddress(v)
+		ok = err == nil && a.Address == v
+	case "uuid":
+		ok = uuid_regex.MatchString(v)
+	case "int":
+		_, err := strconv.ParseInt(v, 10, 64)
+		ok = err == nil
+	case "number":
+		f, err := strconv.ParseFloat(v, 64)
+		ok = err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+	}
+	if !ok {
+		return fmt.Errorf("param %s is not a valid %s", p.name, vd.format)
+	}
+	return nil
+}
+
+// dateValidator checks a value is a time in layout, 2006-01-02 by default.
+type dateValidator struct {
+	layout	string
+}
+
+func (vd *dateValidator) Init(config string) error {
+	vd.layout = date_layout
+	if config != "" {
+		vd.layout = config
+	}
+	return nil
+}
+
+func (vd *dateValidator) Validate(p *Param, v string) error {
+	if v == "" {
+		return nil
+	}
+	if _, err := time.Parse(vd.layout, v); err != nil {
+		return fmt.Errorf("param %s is not a valid date in layout %s", p.name, vd.layout)
+	}
+	return nil
+}
+
+type itemsValidator struct {
+	max		bool
+	count	int
+}
+
+func (vd *itemsValidator) Init(config string) (err error) {
+	if vd.count, err = strconv.Atoi(config); err != nil || vd.count < 0 {
+		return fmt.Errorf("invalid config: %s", config)
+	}
+	return nil
+}
+
+func (vd *itemsValidator) Validate(p *Param, v string) error {
+	return nil
+}
+
+func (vd *itemsValidator) ValidateItems(p *Param, n int) error {
+	if vd.max && n > vd.count {
+		return fmt.Errorf("param %s must have at most %d items", p.name, vd.count)
+	}
+	if !vd.max && n < vd.count {
+		return fmt.Errorf("param %s must have at least %d items", p.name, vd.count)
+	}
+	return nil
+}
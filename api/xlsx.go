@@ -0,0 +1,225 @@
+package api
+
+import (
+	"archive/zip"
+	"database/sql"
+	"db2rest/db"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const xlsx_content_type = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// xlsx_parts are the static parts of a workbook with a single sheet. The
+// styles are referenced by the s attribute of cells: 1 for timestamps, 2 for
+// dates and 3 for times of day.
+var xlsx_parts = []struct {
+	name	string
+	content	string
+}{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="5">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="21" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`</cellXfs>` +
+		`</styleSheet>`},
+}
+
+var xlsx_styles = map[string]string{
+	"time":			"1",
+	"date":			"2",
+	"timeofday":	"3",
+}
+
+const xlsx_header_style = "4"
+
+// xlsx_epoch is day zero of spreadsheet serial dates.
+var xlsx_epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+type XlsxOutput struct {
+	ctx 	*Context
+	fields	[]*Field
+	stream	*Stream
+	zip		*zip.Writer
+	sheet	io.Writer
+	buffer	strings.Builder
+}
+
+func NewXlsxOutput(ctx *Context) *XlsxOutput {
+	return &XlsxOutput{ctx: ctx, stream: NewStream(ctx, xlsx_content_type)}
+}
+
+func (o *XlsxOutput) SQL() (string, []interface{}, error) {
+	return o.ctx.api.SQL(o.ctx);
+}
+
+func (o *XlsxOutput) Columns(cols []*sql.ColumnType) error {
+	o.fields = make([]*Field, len(cols))
+	for i, col := range cols {
+		o.fields[i] = NewCsvField(o.ctx, col)
+		o.fields[i].kind, _ = columnKind(o.fields[i].dbType)
+	}
+
+	attachment(o.ctx, ".xlsx")
+
+	o.zip = zip.NewWriter(o.stream)
+	for _, part := range xlsx_parts {
+		w, err := o.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, part.content); err != nil {
+			return err
+		}
+	}
+	sheet, err := o.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	o.sheet = sheet
+
+	o.buffer.Reset()
+	o.buffer.WriteString(xml.Header)
+	o.buffer.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData><row>`)
+	for _, field := range o.fields {
+		o.appendString(field.name, xlsx_header_style)
+	}
+	o.buffer.WriteString(`</row>`)
+	return o.flushBuffer()
+}
+
+func (o *XlsxOutput) Row(row []*db.Value) error {
+	if err := o.stream.Row(); err != nil {
+		return err
+	}
+	o.buffer.Reset()
+	o.buffer.WriteString(`<row>`)
+	for i, field := range o.fields {
+		if row[i].Null {
+			o.buffer.WriteString(`<c/>`)
+			continue
+		}
+		o.appendCell(field, row[i].Bytes)
+	}
+	o.buffer.WriteString(`</row>`)
+	if err := o.flushBuffer(); err != nil {
+		return err
+	}
+	if o.stream.Due() {
+		if err := o.zip.Flush(); err != nil {
+			return err
+		}
+	}
+	return o.stream.Sync()
+}
+
+func (o *XlsxOutput) appendCell(field *Field, value []byte) {
+	switch field.kind {
+	case "number":
+		if isJsonNumber(value) {
+			o.buffer.WriteString(`<c><v>`)
+			o.buffer.Write(value)
+			o.buffer.WriteString(`</v></c>`)
+			return
+		}
+	case "bool":
+		if v, ok := parseBool(value); ok {
+			if v {
+				o.buffer.WriteString(`<c t="b"><v>1</v></c>`)
+			} else {
+				o.buffer.WriteString(`<c t="b"><v>0</v></c>`)
+			}
+			return
+		}
+	case "time", "date", "timeofday":
+		if t, ok := parseTime(string(value)); ok {
+			o.buffer.WriteString(`<c s="` + xlsx_styles[field.kind] + `"><v>`)
+			o.buffer.WriteString(strconv.FormatFloat(serialDate(t, field.kind), 'f', -1, 64))
+			o.buffer.WriteString(`</v></c>`)
+			return
+		}
+	}
+	o.appendString(string(value), "")
+}
+
+func (o *XlsxOutput) appendString(s, style string) {
+	if style != "" {
+		o.buffer.WriteString(`<c t="inlineStr" s="` + style + `"><is><t xml:space="preserve">`)
+	} else {
+		o.buffer.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	}
+	xml.EscapeText(&o.buffer, []byte(s))
+	o.buffer.WriteString(`</t></is></c>`)
+}
+
+// serialDate converts the wall clock of t to a spreadsheet serial date, the
+// days since 1899-12-30. Times of day are the fraction of a day.
+// The days are counted in seconds, as a time.Duration overflows past 2192.
+func serialDate(t time.Time, kind string) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	if kind == "timeofday" {
+		wall = time.Date(1899, 12, 30, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
+	secs := wall.Unix() - xlsx_epoch.Unix()
+	return float64(secs / 86400) + (float64(secs % 86400) + float64(wall.Nanosecond()) / 1e9) / 86400
+}
+
+func (o *XlsxOutput) flushBuffer() error {
+	_, err := io.WriteString(o.sheet, o.buffer.String())
+	return err
+}
+
+func (o *XlsxOutput) Affected(lastInsertId, rowsAffected int64) {
+
+}
+
+func (o *XlsxOutput) Error(err error) {
+	if !o.stream.Started() {
+		o.ctx.response.Header().Del("Content-Disposition")
+	} else if o.zip != nil {
+		o.zip.Flush()
+	}
+	o.stream.Error(err)
+}
+
+func (o *XlsxOutput) End() {
+	o.buffer.Reset()
+	o.buffer.WriteString(`</sheetData></worksheet>`)
+	if err := o.flushBuffer(); err != nil {
+		o.Error(err)
+		return
+	}
+	if err := o.zip.Close(); err != nil {
+		o.Error(err)
+		return
+	}
+	o.stream.End()
+}
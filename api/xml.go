@@ -0,0 +1,125 @@
+package api
+
+import (
+	"database/sql"
+	"db2rest/db"
+	"encoding/base64"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type XmlOutput struct {
+	ctx 	*Context
+	fields	[]*Field
+	names	[]string
+	stream	*Stream
+	buffer	strings.Builder
+}
+
+func NewXmlOutput(ctx *Context) *XmlOutput {
+	return &XmlOutput{ctx: ctx, stream: NewStream(ctx, "application/xml")}
+}
+
+func (o *XmlOutput) SQL() (string, []interface{}, error) {
+	return o.ctx.api.SQL(o.ctx);
+}
+
+func (o *XmlOutput) Columns(cols []*sql.ColumnType) error {
+	o.fields = NewFields(o.ctx, cols)
+	o.names = make([]string, len(cols))
+	for i, field := range o.fields {
+		o.names[i] = xmlName(field.name)
+	}
+	return nil;
+}
+
+func (o *XmlOutput) Row(row []*db.Value) error {
+	if err := o.stream.Row(); err != nil {
+		return err
+	}
+	o.buffer.Reset()
+	if o.stream.Rows() == 1 {
+		o.buffer.WriteString(xml.Header)
+		o.buffer.WriteString("<" + o.ctx.api.xmlRoot)
+		if o.ctx.api.nulls == "null" {
+			o.buffer.WriteString(` xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"`)
+		}
+		o.buffer.WriteString(">")
+	}
+	o.buffer.WriteString("<" + o.ctx.api.xmlRow + ">")
+	for i, field := range o.fields {
+		name := o.names[i]
+		if row[i].Null {
+			if o.ctx.api.nulls == "null" {
+				o.buffer.WriteString("<" + name + ` xsi:nil="true"/>`)
+			}
+			continue
+		}
+		o.buffer.WriteString("<" + name + ">")
+		xml.EscapeText(&o.buffer, field.Text(row[i].Bytes))
+		o.buffer.WriteString("</" + name + ">")
+	}
+	o.buffer.WriteString("</" + o.ctx.api.xmlRow + ">")
+	o.stream.WriteString(o.buffer.String())
+	return o.stream.Sync()
+}
+
+func (o *XmlOutput) Affected(lastInsertId, rowsAffected int64) {
+
+}
+
+func (o *XmlOutput) Error(err error) {
+	o.stream.Error(err)
+}
+
+func (o *XmlOutput) End() {
+	if o.stream.Started() {
+		o.stream.WriteString("</" + o.ctx.api.xmlRoot + ">")
+		o.stream.End()
+	} else {
+		o.ctx.RespondNotFound()
+	}
+}
+
+// Text returns value as text in the format of its kind, used where values
+// are not typed such as xml content.
+func (f *Field) Text(value []byte) []byte {
+	switch f.kind {
+	case "bool":
+		if v, ok := parseBool(value); ok {
+			return []byte(strconv.FormatBool(v))
+		}
+	case "time", "date", "timeofday":
+		if t, ok := parseTime(string(value)); ok {
+			return []byte(t.Format(f.layout))
+		}
+	case "base64":
+		return []byte(base64.StdEncoding.EncodeToString(value))
+	}
+	return value
+}
+
+// xmlName turns name into a valid xml element name, replacing invalid
+// characters with _ and prefixing names not starting with a letter or _.
+func xmlName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+			b.WriteRune(r)
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+			b.WriteRune(r)
+		case i == 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+			b.WriteRune('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
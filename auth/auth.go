@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"db2rest/conf"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNoKey		= errors.New("api key or token is required")
+	ErrInvalidKey	= errors.New("api key is invalid")
+)
+
+// Auth authenticates requests with an api key or a jwt, as configured.
+type Auth struct {
+	keys	*Keys
+	jwt		*JWT
+}
+
+// New reads the [auth] config: the api keys, and the [auth.jwt] table.
+func New(config *conf.Conf) (*Auth, error) {
+	a := &Auth{}
+	var err error
+	if a.keys, err = NewKeys(config); err != nil {
+		return nil, err
+	}
+	if keys, err := config.Keys("jwt"); err != nil || keys == nil {
+		return a, err
+	}
+	jc, err := config.Get("jwt")
+	if err != nil {
+		return nil, err
+	}
+	if a.jwt, err = NewJWT(jc); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Identity is the caller of a request: an api key, or the claims of a jwt.
+type Identity struct {
+	Key		*Key
+	Claims	map[string]interface{}
+	roles	string
+}
+
+// HasScope reports whether the key, or the scope or scp claim of the token,
+// has scope.
+func (id *Identity) HasScope(scope string) bool {
+	if id.Key != nil {
+		return id.Key.Scopes[scope]
+	}
+	for _, s := range append(strs(id.Claims["scope"]), strs(id.Claims["scp"])...) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the roles claim of the token has any of roles.
+func (id *Identity) HasRole(roles []string) bool {
+	for _, r := range strs(Claim(id.Claims, id.roles)) {
+		for _, role := range roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Key is an api key, known by the sha256 hash of the key only.
+type Key struct {
+	Name	string
+	Hash	string
+	Scopes	map[string]bool
+}
+
+// Keys are the api keys allowed to call the apis, by hash.
+type Keys struct {
+	keys	map[string]*Key
+}
+
+// Hash returns the hex sha256 hash of key, as configured in hash of keys.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKeys reads the api keys of the [auth] config: the keys table array, and
+// the keys of keys_file, a toml or json file with the same keys table array.
+func NewKeys(config *conf.Conf) (*Keys, error) {
+	k := &Keys{keys: make(map[string]*Key)}
+	if err := k.load(config); err != nil {
+		return nil, err
+	}
+	if file := config.GetString("keys_file", ""); file != "" {
+		c, err := conf.LoadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := k.load(c); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("loaded %d api keys\n", len(k.keys))
+	return k, nil
+}
+
+func (k *Keys) load(conf *conf.Conf) error {
+	it, err := conf.Iterator("keys")
+	if err != nil {
+		return err
+	}
+	for it.HasNext() {
+		c, err := it.Next()
+		if err != nil {
+			return err
+		}
+		key := &Key{Name: c.GetString("name", ""), Hash: strings.ToLower(c.GetString("hash", "")), Scopes: make(map[string]bool)}
+		if b, err := hex.DecodeString(key.Hash); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid hash of api key %s", key.Name)
+		}
+		if _, ok := k.keys[key.Hash]; ok {
+			return fmt.Errorf("duplicate api key %s", key.Name)
+		}
+		scopes, err := c.Iterator("scopes")
+		if err != nil {
+			return err
+		}
+		for scopes.HasNext() {
+			s, err := scopes.Next()
+			if err != nil {
+				return err
+			}
+			key.Scopes[s.GetString("_", "")] = true
+		}
+		k.keys[key.Hash] = key
+	}
+	return nil
+}
+
+// Authenticate returns the identity of the request, sent as an api key in
+// the X-API-Key header, or in the Authorization header as a bearer token
+// which is a jwt or an api key.
+func (a *Auth) Authenticate(r *http.Request) (*Identity, error) {
+	if s := r.Header.Get("X-API-Key"); s != "" {
+		return a.keys.Authenticate(s)
+	}
+	s := bearer(r)
+	if s == "" {
+		return nil, ErrNoKey
+	}
+	if a.jwt != nil && strings.Count(s, ".") == 2 {
+		claims, err := a.jwt.Verify(s)
+		if err != nil {
+			return nil, err
+		}
+		return &Identity{Claims: claims, roles: a.jwt.roles}, nil
+	}
+	return a.keys.Authenticate(s)
+}
+
+// Authenticate returns the identity of api key s.
+func (k *Keys) Authenticate(s string) (*Identity, error) {
+	key, ok := k.keys[Hash(s)]
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	return &Identity{Key: key}, nil
+}
+
+func bearer(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// Rule is the authorization an api requires: nothing when Public, otherwise
+// an identity with all of Scopes, any of Roles if set, and the values of
+// Claims.
+type Rule struct {
+	Public	bool
+	Scopes	[]string
+	Roles	[]string
+	Claims	map[string]string
+}
+
+// Restricted reports whether the rule requires any scope, role or claim.
+func (ru *Rule) Restricted() bool {
+	return !ru.Public && (len(ru.Scopes) > 0 || len(ru.Roles) > 0 || len(ru.Claims) > 0)
+}
+
+// check returns why id is not authorized by the rule, or "".
+func (ru *Rule) check(id *Identity) string {
+	for _, s := range ru.Scopes {
+		if !id.HasScope(s) {
+			return "lacks scope " + s
+		}
+	}
+	if len(ru.Roles) > 0 && !id.HasRole(ru.Roles) {
+		return "lacks role " + strings.Join(ru.Roles, " or ")
+	}
+	for name, value := range ru.Claims {
+		if v := Claim(id.Claims, name); v == nil || fmt.Sprint(v) != value {
+			return "lacks claim " + name
+		}
+	}
+	return ""
+}
+
+type contextKey struct{}
+
+// FromContext returns the identity authenticating the request of ctx, or
+// nil for public apis.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
+
+// Middleware authenticates the requests, and authorizes them by the rule of
+// the api, responding 401 without a valid key or token and 403 when the
+// rule is not met.
+func (a *Auth) Middleware(rule func(r *http.Request) *Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ru := rule(r)
+			if ru == nil || ru.Public {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, err := a.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="db2rest"`)
+				respond(w, 401, "unauthorized", err.Error())
+				return
+			}
+			if msg := ru.check(id); msg != "" {
+				respond(w, 403, "forbidden", "caller " + msg)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+		})
+	}
+}
+
+// respond writes an error in the envelope of the api package.
+func respond(w http.ResponseWriter, status int, code, msg string) {
+	b, _ := json.Marshal(struct {
+		Error	string	`json:"error"`
+		Code	string	`json:"code"`
+	}{msg, code})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
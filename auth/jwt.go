@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"db2rest/conf"
+	"db2rest/vexpr"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("token is invalid")
+
+// JWT verifies json web tokens signed with HS256 by a shared secret, or with
+// RS256 or ES256 by a key of a local jwks file.
+type JWT struct {
+	secret		[]byte
+	keys		map[string]interface{}
+	issuer		string
+	audience	string
+	leeway		time.Duration
+	roles		string
+}
+
+type jwtHeader struct {
+	Alg		string	`json:"alg"`
+	Kid		string	`json:"kid"`
+}
+
+type jwk struct {
+	Kty		string	`json:"kty"`
+	Kid		string	`json:"kid"`
+	N		string	`json:"n"`
+	E		string	`json:"e"`
+	Crv		string	`json:"crv"`
+	X		string	`json:"x"`
+	Y		string	`json:"y"`
+}
+
+// NewJWT reads the [auth.jwt] config: secret, jwks_file, issuer, audience,
+// leeway_seconds and roles_claim.
+func NewJWT(config *conf.Conf) (*JWT, error) {
+	j := &JWT{keys: make(map[string]interface{})}
+	j.secret = []byte(config.GetString("secret", ""))
+	j.issuer = config.GetString("issuer", "")
+	j.audience = config.GetString("audience", "")
+	j.leeway = time.Duration(config.GetInt("leeway_seconds", 0)) * time.Second
+	j.roles = config.GetString("roles_claim", "roles")
+	if file := config.GetString("jwks_file", ""); file != "" {
+		if err := j.loadJwks(file); err != nil {
+			return nil, err
+		}
+	}
+	if len(j.secret) == 0 && len(j.keys) == 0 {
+		return nil, errors.New("neither secret nor jwks_file of jwt is set")
+	}
+	return j, nil
+}
+
+func (j *JWT) loadJwks(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys	[]*jwk	`json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return fmt.Errorf("invalid jwks %s: %v", file, err)
+	}
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return fmt.Errorf("invalid jwk %s of %s: %v", k.Kid, file, err)
+		}
+		if key != nil {
+			j.keys[k.Kid] = key
+		}
+	}
+	return nil
+}
+
+// publicKey returns the rsa or P-256 ecdsa key, or nil for other keys.
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// Verify checks the signature of token and its exp, nbf, iss and aud claims,
+// and returns its claims.
+func (j *JWT) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !j.verifySignature(&header, parts[0] + "." + parts[1], sig) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := j.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignature checks sig with the key of the alg of the header, so that
+// a token can not pick a key of another type.
+func (j *JWT) verifySignature(header *jwtHeader, signed string, sig []byte) bool {
+	sum := sha256.Sum256([]byte(signed))
+	switch header.Alg {
+	case "HS256":
+		if len(j.secret) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, j.secret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(sig, mac.Sum(nil))
+	case "RS256":
+		key, ok := j.key(header.Kid, "RS256").(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case "ES256":
+		key, ok := j.key(header.Kid, "ES256").(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, sum[:], r, s)
+	}
+	return false
+}
+
+// key returns the key of kid, or the only key for alg when the token has no
+// kid.
+func (j *JWT) key(kid, alg string) interface{} {
+	if kid != "" {
+		return j.keys[kid]
+	}
+	var found interface{}
+	for _, key := range j.keys {
+		_, rsaKey := key.(*rsa.PublicKey)
+		if rsaKey != (alg == "RS256") {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = key
+	}
+	return found
+}
+
+func (j *JWT) verifyClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return errors.New("token has no exp")
+	}
+	if now.After(exp.Add(j.leeway)) {
+		return errors.New("token is expired")
+	}
+	if v, present := claims["nbf"]; present {
+		nbf, ok := numericDate(v)
+		if !ok || now.Add(j.leeway).Before(nbf) {
+			return errors.New("token is not valid yet")
+		}
+	}
+	if j.issuer != "" && claims["iss"] != j.issuer {
+		return errors.New("token issuer is invalid")
+	}
+	if j.audience != "" && !hasAudience(claims["aud"], j.audience) {
+		return errors.New("token audience is invalid")
+	}
+	return nil
+}
+
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func hasAudience(v interface{}, audience string) bool {
+	switch t := v.(type) {
+	case string:
+		return t == audience
+	case []interface{}:
+		for _, a := range t {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// Claim returns the named claim, or the claim at the dotted path of name
+// such as realm_access.roles.
+func Claim(claims map[string]interface{}, name string) interface{} {
+	if v, ok := claims[name]; ok {
+		return v
+	}
+	v, _ := vexpr.Get(claims, name)
+	return v
+}
+
+// strs returns a claim which is a list of strings, or a space separated
+// string as scope.
+func strs(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return strings.Fields(t)
+	case []interface{}:
+		s := make([]string, 0, len(t))
+		for _, i := range t {
+			if str, ok := i.(string); ok {
+				s = append(s, str)
+			}
+		}
+		return s
+	}
+	return nil
+}
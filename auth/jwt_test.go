@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	rsa_key, _	= rsa.GenerateKey(rand.Reader, 2048)
+	ec_key, _	= ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret		= []byte("secret")
+)
+
+func segment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a token of claims signed with alg, by the test keys.
+func sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := segment(header) + "." + segment(claims)
+	sum := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS256":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, rsa_key, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ec_key, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32 - len(rb):32], rb)
+		copy(sig[64 - len(sb):], sb)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func claims(extra map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{"sub": "1", "exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	hs := &JWT{secret: secret, keys: map[string]interface{}{}}
+	keys := &JWT{keys: map[string]interface{}{"rsa": &rsa_key.PublicKey, "ec": &ec_key.PublicKey}}
+	rsaOnly := &JWT{keys: map[string]interface{}{"rsa": &rsa_key.PublicKey}}
+	twoRsa := &JWT{keys: map[string]interface{}{"a": &rsa_key.PublicKey, "b": &rsa_key.PublicKey}}
+	leeway := &JWT{secret: secret, leeway: time.Minute}
+	aud := &JWT{secret: secret, audience: "api", issuer: "idp"}
+	now := time.Now().Unix()
+	none := segment(map[string]string{"alg": "none"}) + "." + segment(claims(nil)) + "."
+	parts := strings.Split(sign(t, "HS256", "", claims(nil)), ".")
+	tampered := parts[0] + "." + segment(claims(map[string]interface{}{"sub": "2"})) + "." + parts[2]
+	short := sign(t, "ES256", "ec", claims(nil))
+	short = short[:len(short) - 3]
+
+	tests := []struct {
+		name	string
+		jwt		*JWT
+		token	string
+		valid	bool
+	}{
+		{"hs256", hs, sign(t, "HS256", "", claims(nil)), true},
+		{"alg none", hs, none, false},
+		{"alg none signed", hs, none + base64.RawURLEncoding.EncodeToString(secret), false},
+		{"hs256 without secret", keys, sign(t, "HS256", "", claims(nil)), false},
+		{"hs256 tampered", hs, tampered, false},
+		{"rs256 by kid", keys, sign(t, "RS256", "rsa", claims(nil)), true},
+		{"rs256 with ec key of kid", keys, sign(t, "RS256", "ec", claims(nil)), false},
+		{"rs256 with unknown kid", keys, sign(t, "RS256", "other", claims(nil)), false},
+		{"es256 by kid", keys, sign(t, "ES256", "ec", claims(nil)), true},
+		{"es256 with rsa key of kid", keys, sign(t, "ES256", "rsa", claims(nil)), false},
+		{"es256 short signature", keys, short, false},
+		{"rs256 without kid", keys, sign(t, "RS256", "", claims(nil)), true},
+		{"es256 without kid", keys, sign(t, "ES256", "", claims(nil)), true},
+		{"es256 without kid nor ec key", rsaOnly, sign(t, "ES256", "", claims(nil)), false},
+		{"rs256 without kid of two keys", twoRsa, sign(t, "RS256", "", claims(nil)), false},
+		{"no exp", hs, sign(t, "HS256", "", map[string]interface{}{"sub": "1"}), false},
+		{"expired", hs, sign(t, "HS256", "", claims(map[string]interface{}{"exp": now - 10})), false},
+		{"expired in leeway", leeway, sign(t, "HS256", "", claims(map[string]interface{}{"exp": now - 10})), true},
+		{"expired past leeway", leeway, sign(t, "HS256", "", claims(map[string]interface{}{"exp": now - 120})), false},
+		{"nbf in future", hs, sign(t, "HS256", "", claims(map[string]interface{}{"nbf": now + 10})), false},
+		{"nbf in leeway", leeway, sign(t, "HS256", "", claims(map[string]interface{}{"nbf": now + 10})), true},
+		{"nbf past leeway", leeway, sign(t, "HS256", "", claims(map[string]interface{}{"nbf": now + 120})), false},
+		{"nbf not a date", hs, sign(t, "HS256", "", claims(map[string]interface{}{"nbf": "now"})), false},
+		{"aud string", aud, sign(t, "HS256", "", claims(map[string]interface{}{"aud": "api", "iss": "idp"})), true},
+		{"aud array", aud, sign(t, "HS256", "", claims(map[string]interface{}{"aud": []string{"web", "api"}, "iss": "idp"})), true},
+		{"aud other string", aud, sign(t, "HS256", "", claims(map[string]interface{}{"aud": "web", "iss": "idp"})), false},
+		{"aud other array", aud, sign(t, "HS256", "", claims(map[string]interface{}{"aud": []string{"web"}, "iss": "idp"})), false},
+		{"aud missing", aud, sign(t, "HS256", "", claims(map[string]interface{}{"iss": "idp"})), false},
+		{"iss other", aud, sign(t, "HS256", "", claims(map[string]interface{}{"aud": "api", "iss": "other"})), false},
+		{"two segments", hs, "a.b", false},
+	}
+	for _, tt := range tests {
+		_, err := tt.jwt.Verify(tt.token)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: Verify() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	j := &JWT{secret: secret}
+	got, err := j.Verify(sign(t, "HS256", "", claims(map[string]interface{}{"n": 7, "realm_access": map[string]interface{}{"roles": []string{"admin"}}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["n"] != json.Number("7") {
+		t.Errorf("claim n = %#v, want json.Number 7", got["n"])
+	}
+	if roles := strs(Claim(got, "realm_access.roles")); len(roles) != 1 || roles[0] != "admin" {
+		t.Errorf("claim realm_access.roles = %v, want [admin]", roles)
+	}
+}
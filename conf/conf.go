@@ -128,6 +128,10 @@ func (c *Conf) Len(name string) (int, error) {
 	return vexpr.Len(c.data, name)
 }
 
+func (c *Conf) Keys(name string) ([]string, error) {
+	return vexpr.Keys(c.data, name)
+}
+
 func (c *Conf) Iterator(name string) (*Iterator, error) {
 	i, err := vexpr.Len(c.data, name)
 	if err != nil {
@@ -1,42 +1,54 @@
 package db
 
 import (
+	"fmt"
 	"errors"
+	"context"
 	"db2rest/conf"
 	"strings"
+	"strconv"
 	"log"
 	"time"
 	"regexp"
 	"net/url"
 	"database/sql"
+	"github.com/lib/pq"
 )
 
 type Client struct {
+	name		string
 	conf		*conf.Conf
 	regex1		*regexp.Regexp
 	regex2		*regexp.Regexp
+	dialect		*dialect
 	db *sql.DB
 }
 
-func New(conf *conf.Conf) (*Client, error) {
-	c := &Client{conf: conf}
-	dsn := conf.GetString("db.url", "")
+// New opens the datasource configured by conf, which holds the keys of a
+// [db] or [db.<name>] table.
+func New(name string, conf *conf.Conf) (*Client, error) {
+	c := &Client{name: name, conf: conf}
+	dsn := conf.GetString("url", "")
 	if dsn == "" {
-		return nil, errors.New("db.url is not set")
+		return nil, fmt.Errorf("url of datasource %s is not set", c)
 	}
 	url, err := url.Parse(dsn)
 	if err != nil {
-		return nil, errors.New("db.url in wrong format")
+		return nil, fmt.Errorf("url of datasource %s in wrong format", c)
 	}
-	log.Printf("open db %s://%s:****@%s%s\n", url.Scheme, url.User.Username(), url.Host, url.RequestURI())
-	db, err := sql.Open("postgres", dsn)
+	c.dialect, err = lookupDialect(conf.GetString("driver", ""), url)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("open %s db %s %s://%s:****@%s%s\n", c.dialect.driver, c, url.Scheme, url.User.Username(), url.Host, url.RequestURI())
+	db, err := sql.Open(c.dialect.driver, c.dialect.dataSource(dsn, url))
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(conf.GetInt("db.max_open_conn", 3))
-	db.SetMaxIdleConns(conf.GetInt("db.max_idle_conn", 3))
-	db.SetConnMaxLifetime(time.Duration(conf.GetInt("db.max_lifetime_minute", 5))*time.Minute)
+	db.SetMaxOpenConns(conf.GetInt("max_open_conn", 3))
+	db.SetMaxIdleConns(conf.GetInt("max_idle_conn", 3))
+	db.SetConnMaxLifetime(time.Duration(conf.GetInt("max_lifetime_minute", 5))*time.Minute)
 
 	if err := db.Ping(); err != nil {
 		return nil, err
@@ -49,123 +61,348 @@ func New(conf *conf.Conf) (*Client, error) {
 	return c, nil
 }
 
-func newRow(n int) ([]*[]byte, []interface{}) {
-	vals1 := make([]*[]byte, n)
+// Value is a scanned column. Like sql.RawBytes, Bytes is only valid until
+// the next row is scanned. Null distinguishes SQL NULL from an empty value.
+type Value struct {
+	Bytes	[]byte
+	Null	bool
+	buf		[]byte
+}
+
+func (v *Value) Scan(src interface{}) error {
+	v.Null = false
+	switch t := src.(type) {
+	case nil:
+		v.Null = true
+		v.Bytes = nil
+		return nil
+	case []byte:
+		v.Bytes = t
+		return nil
+	case string:
+		v.buf = append(v.buf[:0], t...)
+	case time.Time:
+		v.buf = t.AppendFormat(v.buf[:0], time.RFC3339Nano)
+	case bool:
+		v.buf = strconv.AppendBool(v.buf[:0], t)
+	case int64:
+		v.buf = strconv.AppendInt(v.buf[:0], t, 10)
+	case float64:
+		v.buf = strconv.AppendFloat(v.buf[:0], t, 'g', -1, 64)
+	default:
+		v.buf = append(v.buf[:0], fmt.Sprint(t)...)
+	}
+	v.Bytes = v.buf
+	return nil
+}
+
+func newRow(n int) ([]*Value, []interface{}) {
+	vals1 := make([]*Value, n)
 	vals2 := make([]interface{}, n)
 	for i := 0; i < n; i++ {
-		r := new([]byte)
+		r := new(Value)
 		vals1[i] = r
 		vals2[i] = r
 	}
 	return vals1, vals2
 }
 
-func resetRow(row []*[]byte) {
-	for _, val := range row {
-		(*val) = (*val)[:0]
-	}
+// Query runs the query of out and writes its rows to out. The query is
+// canceled when ctx is done.
+func (c *Client) Query(ctx context.Context, session *Session, out Output) {
+	c.run(ctx, session, out, nil, c.query)
 }
 
-func (c *Client) Query(out Output) {
-	sql, err := out.SQL()
+func (c *Client) query(ctx context.Context, q queryer, out Output) error {
+	if co, ok := out.(CountOutput); ok {
+		if err := c.count(ctx, q, co); err != nil {
+			return err
+		}
+	}
+
+	sql, args, err := out.SQL()
 	if err != nil {
-		out.Error(err)
-		return
+		return err
 	}
-	log.Printf("sql: %s\n", sql)
+	log.Printf("sql: %s %v\n", sql, args)
 
-	rows, err := c.db.Query(sql)
+	rows, err := q.QueryContext(ctx, sql, args...)
 	if err != nil {
-		out.Error(err)
-		return
+		return err
 	}
 	defer rows.Close()
 
 	cols, err := rows.ColumnTypes()
 	if err != nil {
-		out.Error(err)
-		return
+		return err
 	}
 	if err := out.Columns(cols); err != nil {
-		out.Error(err)
-		return
+		return err
 	}
 
 	val1, val2 := newRow(len(cols))
 	for rows.Next() {
-		resetRow(val1)
 		if err := rows.Scan(val2...); err != nil {
-			out.Error(err)
-			return
+			return err
 		}
 		if err := out.Row(val1); err != nil {
-			out.Error(err)
-			return
+			return err
 		}
 	}
-	if err := rows.Err(); err != nil {
-		out.Error(err)
+	return rows.Err()
+}
+
+func (c *Client) count(ctx context.Context, q queryer, out CountOutput) error {
+	sql, args, err := out.CountSQL()
+	if err != nil || sql == "" {
+		return err
+	}
+	log.Printf("sql: %s %v\n", sql, args)
+
+	var total int64
+	if err := q.QueryRowContext(ctx, sql, args...).Scan(&total); err != nil {
+		return err
+	}
+	out.Count(total)
+	return nil
+}
+
+func (c *Client) Exec(ctx context.Context, session *Session, out Output) {
+	c.run(ctx, session, out, nil, func(ctx context.Context, q queryer, out Output) error {
+		sql, args, err := out.SQL()
+		if err != nil {
+			return err
+		}
+		return c.exec(ctx, q, out, sql, args)
+	})
+}
+
+// Transaction runs the statements of out, which must be a TxOutput, in one
+// transaction and rolls it back on the first error.
+func (c *Client) Transaction(ctx context.Context, session *Session, out Output) {
+	txo, ok := out.(TxOutput)
+	if !ok {
+		out.Error(errors.New("output does not support transaction"))
 		return
 	}
 
-	out.End()
+	c.run(ctx, session, out, txo.TxOptions(), func(ctx context.Context, q queryer, out Output) error {
+		for i := 0; i < txo.Statements(); i++ {
+			sql, args, err := txo.Statement(i)
+			if err != nil {
+				return err
+			}
+			if err := c.exec(ctx, q, out, sql, args); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (c *Client) Exec(out Output) {
-	sql, err := out.SQL()
+// run runs f and ends out, or reports the error of f to out. f runs in a
+// transaction when opts is set or session is not empty, whose settings are
+// set local to the transaction first.
+func (c *Client) run(ctx context.Context, session *Session, out Output, opts *sql.TxOptions, f func(context.Context, queryer, Output) error) {
+	if opts == nil && session.empty() {
+		if err := f(ctx, c.db, out); err != nil {
+			out.Error(err)
+			return
+		}
+		out.End()
+		return
+	}
+
+	tx, err := c.db.BeginTx(ctx, opts)
 	if err != nil {
 		out.Error(err)
 		return
 	}
-	log.Printf("sql: %s\n", sql)
-
-	res, err := c.db.Exec(sql)
+	err = c.setSession(ctx, tx, session)
+	if err == nil {
+		err = f(ctx, tx, out)
+	}
 	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			log.Printf("fail to rollback: %v\n", err)
+		}
+		out.Error(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
 		out.Error(err)
 		return
 	}
+	out.End()
+}
+
+// setSession sets the settings and role of session local to tx.
+func (c *Client) setSession(ctx context.Context, tx *sql.Tx, session *Session) error {
+	if session.empty() {
+		return nil
+	}
+	for _, s := range session.Settings {
+		sql := "select set_config(" + c.Placeholder(1) + ", " + c.Placeholder(2) + ", true)"
+		log.Printf("sql: %s [%s %s]\n", sql, s.Name, s.Value)
+		if _, err := tx.ExecContext(ctx, sql, s.Name, s.Value); err != nil {
+			return err
+		}
+	}
+	if session.Role != "" {
+		sql := "set local role " + pq.QuoteIdentifier(session.Role)
+		log.Printf("sql: %s\n", sql)
+		if _, err := tx.ExecContext(ctx, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryer is the database or a transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (c *Client) exec(ctx context.Context, db queryer, out Output, sql string, args []interface{}) error {
+	log.Printf("sql: %s %v\n", sql, args)
 
-	// lastId, err := res.LastInsertId()
-	// if err != nil {
-	// 	out.Error(err)
-	// 	return
-	// }
+	res, err := db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	var lastId int64
+	if c.dialect.lastInsertId {
+		lastId, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+	}
 	rowCnt, err := res.RowsAffected()
 	if err != nil {
-		out.Error(err)
-		return
+		return err
 	}
 
-	out.Affected(0, rowCnt)
-	out.End()
+	out.Affected(lastId, rowCnt)
+	return nil
 }
 
 func (c *Client) Close() (err error) {
 	if c.db != nil {
-		log.Println("closing db")
+		log.Printf("closing db %s\n", c)
 		err = c.db.Close();
 	}
 	return 
 }
 
 func (c *Client) FormatSQL(sql string) string {
-	if c.conf.GetBool("db.replace_newline_with_space", false) {
+	if c.conf.GetBool("replace_newline_with_space", false) {
+		sql = c.dialect.stripComments(sql)
 		sql = c.regex2.ReplaceAllString(sql, " ")
 		sql = strings.TrimSpace(sql)
-	} else if c.conf.GetBool("db.remove_empty_line", true) {
+	} else if c.conf.GetBool("remove_empty_line", true) {
 		sql = c.regex1.ReplaceAllString(sql, "\n")
 	}
 	return sql
 }
 
+func (c *Client) String() string {
+	if c.name == "" {
+		return "(default)"
+	}
+	return c.name
+}
+
+// Driver returns the name of the sql driver of the datasource.
+func (c *Client) Driver() string {
+	return c.dialect.driver
+}
+
+// Placeholder returns the bind variable referring to the i-th (1-based) argument.
+func (c *Client) Placeholder(i int) string {
+	return c.dialect.placeholder(i)
+}
+
+// Conf returns the config of the datasource.
+func (c *Client) Conf() *conf.Conf {
+	return c.conf
+}
+
+// Quote returns s as a string literal of the sql dialect.
+func (c *Client) Quote(s string) string {
+	return c.dialect.quote(s)
+}
+
 type Output interface {
-	SQL() (string, error)
+	SQL() (string, []interface{}, error)
 
 	Columns([]*sql.ColumnType) error
-	Row([]*[]byte) error
+	Row([]*Value) error
 
 	Affected(lastInsertId, rowsAffected int64)
 
 	Error(error)
 	End()
 }
+
+// CountOutput is an Output that also wants the total number of rows, counted
+// by CountSQL before running its query. An empty CountSQL skips counting.
+type CountOutput interface {
+	Output
+
+	CountSQL() (string, []interface{}, error)
+	Count(total int64)
+}
+
+// Session is the settings and role of the session statements run with, set
+// local to their transaction with set_config and set local role. Only
+// postgres supports them. A nil or empty Session runs the statements without
+// a transaction of their own.
+type Session struct {
+	Settings	[]Setting
+	Role		string
+}
+
+type Setting struct {
+	Name	string
+	Value	string
+}
+
+func (s *Session) empty() bool {
+	return s == nil || (len(s.Settings) == 0 && s.Role == "")
+}
+
+// TxOutput is an Output of several statements run in one transaction.
+type TxOutput interface {
+	Output
+
+	Statements() int
+	Statement(i int) (string, []interface{}, error)
+	TxOptions() *sql.TxOptions
+}
+
+var isolations = map[string]sql.IsolationLevel{
+	"default":			sql.LevelDefault,
+	"read_uncommitted":	sql.LevelReadUncommitted,
+	"read_committed":	sql.LevelReadCommitted,
+	"write_committed":	sql.LevelWriteCommitted,
+	"repeatable_read":	sql.LevelRepeatableRead,
+	"snapshot":			sql.LevelSnapshot,
+	"serializable":		sql.LevelSerializable,
+	"linearizable":		sql.LevelLinearizable,
+}
+
+// ParseIsolation parses isolation level names such as "read committed" or
+// "repeatable_read".
+func ParseIsolation(s string) (sql.IsolationLevel, error) {
+	name := strings.ToLower(strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-'
+	}), "_"))
+	level, ok := isolations[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid isolation level %s", s)
+	}
+	return level, nil
+}
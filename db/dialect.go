@@ -0,0 +1,170 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type dialect struct {
+	driver			string
+	lastInsertId	bool
+	placeholder		func(i int) string
+	quote			func(s string) string
+	comments		[]string
+	backslash		bool
+	classify		func(err error) *Error
+}
+
+var dialects = map[string]*dialect{
+	"postgres": {
+		driver:			"postgres",
+		lastInsertId:	false,
+		placeholder:	func(i int) string { return "$" + strconv.Itoa(i) },
+		quote:			quoteStandard,
+		comments:		[]string{"--"},
+		classify:		classifyPostgres,
+	},
+	"mysql": {
+		driver:			"mysql",
+		lastInsertId:	true,
+		placeholder:	func(i int) string { return "?" },
+		quote:			quoteBackslash,
+		comments:		[]string{"--", "#"},
+		backslash:		true,
+		classify:		classifyMysql,
+	},
+	"sqlite3": {
+		driver:			"sqlite3",
+		lastInsertId:	true,
+		placeholder:	func(i int) string { return "?" },
+		quote:			quoteStandard,
+		comments:		[]string{"--"},
+		classify:		classifySqlite,
+	},
+}
+
+var schemes = map[string]string{
+	"postgres":		"postgres",
+	"postgresql":	"postgres",
+	"mysql":		"mysql",
+	"sqlite":		"sqlite3",
+	"sqlite3":		"sqlite3",
+	"file":			"sqlite3",
+}
+
+// lookupDialect picks the dialect from the explicit driver name, or from the
+// scheme of the url when driver is empty.
+func lookupDialect(driver string, u *url.URL) (*dialect, error) {
+	if driver == "" {
+		driver = schemes[strings.ToLower(u.Scheme)]
+		if driver == "" {
+			return nil, fmt.Errorf("unsupported db url scheme %s", u.Scheme)
+		}
+	}
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported db driver %s", driver)
+	}
+	return d, nil
+}
+
+// dataSource converts the configured url to the data source name expected by
+// the driver. Urls without a known scheme are passed through untouched.
+func (d *dialect) dataSource(dsn string, u *url.URL) string {
+	if schemes[strings.ToLower(u.Scheme)] != d.driver {
+		return dsn
+	}
+	switch d.driver {
+	case "mysql":
+		s := "tcp(" + u.Host + ")" + u.EscapedPath()
+		if u.User != nil {
+			user := u.User.Username()
+			if p, ok := u.User.Password(); ok {
+				user += ":" + p
+			}
+			s = user + "@" + s
+		}
+		if u.RawQuery != "" {
+			s += "?" + u.RawQuery
+		}
+		return s
+	case "sqlite3":
+		s := dsn[len(u.Scheme)+1:]
+		return strings.TrimPrefix(s, "//")
+	default:
+		return dsn
+	}
+}
+
+func quoteStandard(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func quoteBackslash(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// stripComments removes line comments outside of quoted strings and
+// identifiers, so that joining lines does not comment out the rest of the sql.
+// A backslash escapes the next character in mysql strings, and in postgres
+// E'' strings.
+func (d *dialect) stripComments(sql string) string {
+	var b strings.Builder
+	var quote byte
+	escapes := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		if quote != 0 {
+			b.WriteByte(c)
+			if c == '\\' && escapes && i + 1 < len(sql) {
+				i++
+				b.WriteByte(sql[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+			escapes = c != '`' && (d.backslash || c == '\'' && d.driver == "postgres" && escapeString(sql[:i]))
+			b.WriteByte(c)
+			continue
+		}
+		comment := false
+		for _, prefix := range d.comments {
+			if strings.HasPrefix(sql[i:], prefix) {
+				comment = true
+				break
+			}
+		}
+		if !comment {
+			b.WriteByte(c)
+			continue
+		}
+		for i < len(sql) && sql[i] != '\n' {
+			i++
+		}
+		if i < len(sql) {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// escapeString reports whether the string quoted after sql is a postgres
+// escape string, prefixed with E.
+func escapeString(sql string) bool {
+	n := len(sql)
+	if n == 0 || sql[n-1] != 'E' && sql[n-1] != 'e' {
+		return false
+	}
+	if n == 1 {
+		return true
+	}
+	c := sql[n-2]
+	return !(c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z')
+}
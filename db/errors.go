@@ -0,0 +1,130 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Classes of database errors, see Client.Classify.
+const (
+	UniqueViolation			= "unique_violation"
+	ForeignKeyViolation		= "foreign_key_violation"
+	ForeignKeyRestrict		= "foreign_key_restrict"
+	NotNullViolation		= "not_null_violation"
+	CheckViolation			= "check_violation"
+	SerializationFailure	= "serialization_failure"
+	QueryCanceled			= "query_canceled"
+)
+
+// Error is an error of the database driver, with its class, or an empty
+// class for other errors, and the name of the violated constraint if known.
+type Error struct {
+	Class		string
+	Constraint	string
+	Err			error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Classify returns err as an Error when it is an error of the driver of the
+// datasource, or nil for other errors.
+func (c *Client) Classify(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return c.dialect.classify(err)
+}
+
+func classifyPostgres(err error) *Error {
+	pe, ok := err.(*pq.Error)
+	if !ok {
+		return nil
+	}
+	e := &Error{Constraint: pe.Constraint, Err: err}
+	switch pe.Code {
+	case "23505":
+		e.Class = UniqueViolation
+	case "23503":
+		// the same code is raised deleting a row which is still referenced
+		e.Class = ForeignKeyViolation
+		if strings.Contains(pe.Detail, "still referenced") {
+			e.Class = ForeignKeyRestrict
+		}
+	case "23502":
+		e.Class = NotNullViolation
+	case "23514":
+		e.Class = CheckViolation
+	case "40001", "40P01":
+		e.Class = SerializationFailure
+	case "57014":
+		e.Class = QueryCanceled
+	}
+	return e
+}
+
+var mysql_key_regex = regexp.MustCompile("(?:for key|CONSTRAINT|Check constraint) [`']([^`']+)[`']")
+
+func classifyMysql(err error) *Error {
+	me, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return nil
+	}
+	e := &Error{Err: err}
+	if m := mysql_key_regex.FindStringSubmatch(me.Message); m != nil {
+		e.Constraint = m[1]
+	}
+	switch me.Number {
+	case 1062:
+		e.Class = UniqueViolation
+	case 1452:
+		e.Class = ForeignKeyViolation
+	case 1451:
+		e.Class = ForeignKeyRestrict
+	case 1048:
+		e.Class = NotNullViolation
+	case 3819:
+		e.Class = CheckViolation
+	case 1205, 1213:
+		e.Class = SerializationFailure
+	case 1317, 3024:
+		e.Class = QueryCanceled
+	}
+	return e
+}
+
+func classifySqlite(err error) *Error {
+	se, ok := err.(sqlite3.Error)
+	if !ok {
+		return nil
+	}
+	e := &Error{Err: err}
+	switch se.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		e.Class = UniqueViolation
+	case sqlite3.ErrConstraintForeignKey:
+		e.Class = ForeignKeyViolation
+	case sqlite3.ErrConstraintNotNull:
+		e.Class = NotNullViolation
+	case sqlite3.ErrConstraintCheck:
+		e.Class = CheckViolation
+	}
+	if e.Class == UniqueViolation || e.Class == CheckViolation {
+		// sqlite names unique constraints by their columns, such as
+		// UNIQUE constraint failed: t.c, and check constraints by name
+		if i := strings.LastIndex(se.Error(), "failed: "); i >= 0 {
+			e.Constraint = se.Error()[i + len("failed: "):]
+		}
+	}
+	switch se.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		e.Class = SerializationFailure
+	case sqlite3.ErrInterrupt:
+		e.Class = QueryCanceled
+	}
+	return e
+}
@@ -4,7 +4,9 @@ go 1.12
 
 require (
 	github.com/BurntSushi/toml v0.3.1
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/gorilla/mux v1.7.1
 	github.com/iancoleman/strcase v0.0.0-20190422225806-e506e3ef7365
 	github.com/lib/pq v1.1.0
+	github.com/mattn/go-sqlite3 v1.10.0
 )
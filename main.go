@@ -2,18 +2,28 @@ package main
 
 import (
 	"db2rest/api"
+	"db2rest/auth"
 	"db2rest/conf"
+	"fmt"
 	"syscall"
 	"os"
 	"log"
 	"flag"
 	_ "github.com/lib/pq"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
-	var file string
+	var file, key string
     flag.StringVar(&file, "c", "test.toml", "config file")
+    flag.StringVar(&key, "hash-key", "", "print the hash of an api key to configure")
 	flag.Parse()
+
+	if key != "" {
+		fmt.Println(auth.Hash(key))
+		os.Exit(0)
+	}
 	
 	if file == "" {
 		flag.Usage()
@@ -1,6 +1,7 @@
 package vexpr
 
 import (
+	"sort"
 	"strconv"
 	"regexp"
 	"reflect"
@@ -40,6 +41,27 @@ func Len(data interface{}, expr string) (int, error) {
 	return vv.Len(), nil
 }
 
+func Keys(data interface{}, expr string) ([]string, error) {
+	v, err := Get(data, expr)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	vv := reflect.ValueOf(v)
+	if vv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("%s is not map (%v)", expr, vv.Type())
+	}
+	keys := make([]string, 0, vv.Len())
+	for _, k := range vv.MapKeys() {
+		keys = append(keys, fmt.Sprint(k.Interface()))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
 func GetString(data interface{}, expr string, def string) (string, error) {
 	v, err := Get(data, expr)
 	if err != nil {